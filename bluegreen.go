@@ -0,0 +1,44 @@
+package f5
+
+import (
+	"context"
+	"time"
+)
+
+// blueGreenTimeout is how long a new run has to be ready with -blue-green
+// before the previous run is stopped anyway, unless -ready-timeout is set.
+const blueGreenTimeout = 30 * time.Second
+
+// swap stops the previous run prev once the new run p is ready, for
+// -blue-green, so that one of them serves at all times. If p exits before
+// being ready, prev goes on as the current run. If p is not ready in time,
+// prev is stopped anyway, as on a usual restart.
+func (r *Run) swap(ctx context.Context, prev, p *proc, ready <-chan struct{}) {
+	timeout := r.cfg.ReadyTimeout
+	if timeout <= 0 {
+		timeout = blueGreenTimeout
+	}
+	t := time.NewTimer(timeout)
+	defer t.Stop()
+	select {
+	case <-ctx.Done():
+	case <-prev.done:
+		return
+	case <-ready:
+		r.printf(colorSuccess, "Process %d ready, stopping process %d", p.Pid, prev.Pid)
+	case <-p.done:
+		r.printf(colorWarn, "Process %d exited before being ready, keeping process %d", p.Pid, prev.Pid)
+		r.mu.Lock()
+		if r.proc == p {
+			r.proc = prev
+		}
+		r.mu.Unlock()
+		if err := r.writePIDFile(prev.Pid); err != nil {
+			r.printf(colorError, "Cannot write pid file: %v", err)
+		}
+		return
+	case <-t.C:
+		r.printf(colorWarn, "Process %d not ready after %s, stopping process %d", p.Pid, timeout, prev.Pid)
+	}
+	r.stop(prev)
+}
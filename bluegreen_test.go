@@ -0,0 +1,43 @@
+package f5
+
+import (
+	"context"
+	"io"
+	"log"
+	"testing"
+	"time"
+)
+
+// TestBlueGreenFallback checks that with -blue-green the previous run
+// goes on as the current one when the new run exits before being ready.
+func TestBlueGreenFallback(t *testing.T) {
+	r, err := NewWithConfig(Config{BlueGreen: true, ReadyRegex: "^ready$", PortEnv: "PORT", RunEnv: true},
+		"sh", "-c", `[ "$F5_RUN" = 1 ] || exit 1; echo ready; exec sleep 60`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.logger = log.New(io.Discard, "", 0)
+	r.out, r.stdout, r.stderr = io.Discard, io.Discard, io.Discard
+	t.Cleanup(r.Close)
+	ctx := context.Background()
+
+	r.restartFor(ctx, trigger{reason: "start"})
+	first, _ := r.status()
+	r.restartFor(ctx, trigger{reason: "key"})
+	second, _ := r.status()
+	if second == first {
+		t.Fatal("no second run started")
+	}
+	select {
+	case <-second.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("second run did not exit")
+	}
+	time.Sleep(100 * time.Millisecond)
+	if first.exited() {
+		t.Error("first run stopped after the second one exited before being ready")
+	}
+	if p, _ := r.status(); p != first {
+		t.Errorf("current run is #%d, want the first one back", p.run)
+	}
+}
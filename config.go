@@ -0,0 +1,447 @@
+package f5
+
+import (
+	"encoding/json"
+	"fmt"
+	"io/fs"
+	"net/http"
+	"reflect"
+	"strings"
+	"time"
+)
+
+// Config holds the settings of a Run. The zero value is the default
+// behavior of f5.
+type Config struct {
+	// Debug logs every file system event, and why it was accepted or
+	// rejected.
+	Debug bool `json:"debug"`
+	// Roots are the directory trees to watch. When empty, the working
+	// directory is watched with the default extensions. A change under
+	// any root restarts the one command run, as there are no per-root
+	// commands.
+	Roots []Root `json:"dir"`
+	// AlsoWatch lists more directories or single files to watch, such as
+	// generated files outside of the project. Directories are filtered
+	// like the roots; files are watched whatever their extension.
+	AlsoWatch []string `json:"also-watch"`
+	// MaxDepth, if set, is the number of levels of directories watched
+	// below each root: 0 watches the root directory only, 1 the
+	// directories in it as well, and so on.
+	MaxDepth *int `json:"max-depth,omitempty"`
+	// OnlyDirs, when set, limits watching to directories inside one of
+	// them. Ignore patterns still apply within.
+	OnlyDirs []string `json:"only-dir"`
+	// Extensions adjusts the default extensions: ".rs" adds an extension,
+	// and "-.php" removes one.
+	Extensions []string `json:"ext"`
+	// All watches every file, whatever its extension, so that any change
+	// not ignored by a pattern restarts the command. Files written by the
+	// command itself, such as logs and build output, then restart it in a
+	// loop unless they are ignored.
+	All bool `json:"all"`
+	// WatchDir, if set, decides whether a directory of a root is watched,
+	// given its entries, such as when it has a BUILD file in it. Files
+	// in the directories watched are still filtered by extension and
+	// pattern. When nil, a directory is watched when it has a watched file
+	// in it.
+	WatchDir func(dir string, entries []fs.DirEntry) bool `json:"-"`
+	// WatchManifests also watches dependency manifests such as go.mod,
+	// package.json and Cargo.toml.
+	WatchManifests bool `json:"watch-manifests"`
+	// DetectShebang also watches files without an extension that start
+	// with a shebang line for a known interpreter. It reads the head of
+	// every such file, so it is off by default.
+	DetectShebang bool `json:"detect-shebang"`
+	// Settle, if set, waits for a changed file to keep the same size and
+	// modification time for this long before restarting, so that a file
+	// still being written does not restart the command.
+	Settle time.Duration `json:"settle"`
+	// Warmup, if set, ignores file changes for this long after Start, so
+	// that files touched by tools starting along with f5 do not restart
+	// the command right away. Keys still restart it.
+	Warmup time.Duration `json:"warmup"`
+	// GoSemantic skips restarting for changes to Go files that change only
+	// comments or formatting, by comparing their syntax trees. A file that
+	// does not parse restarts the command.
+	GoSemantic bool `json:"go-semantic"`
+	// ImportAware restarts a go run or go build command only for changes
+	// to Go files of the packages it imports, as listed by go list after
+	// each start. Other files restart it as usual, and so does any Go file
+	// when the packages cannot be listed.
+	ImportAware bool `json:"import-aware"`
+	// RestartOnDelete also restarts when a watched file is deleted or
+	// renamed away, not only when one is written.
+	RestartOnDelete bool `json:"restart-on-delete"`
+	// Ops names the operations on watched files that restart the command,
+	// among "write", "create", "remove", "rename", "chmod" and "all". It is
+	// "write" if empty. Removing and renaming away count as deletions.
+	Ops []string `json:"ops"`
+	// Resume reports the watched files changed while f5 was not running,
+	// when it starts. The latest modification time of a watched file it
+	// saw is kept for that as JSON, {"dir": ..., "modtime": ...}, in
+	// f5/resume-<hash of the working directory>.json in the user's cache
+	// directory, such as ~/.cache.
+	Resume bool `json:"resume"`
+	// WatchGit also restarts when the commit checked out in the git
+	// repository of a root changes, as on pull, commit or checkout, even
+	// when no watched file changed.
+	WatchGit bool `json:"watch-git"`
+	// Include lists gitignore style patterns of files to watch. When set,
+	// only matching files are watched, regardless of their extension.
+	Include []string `json:"include"`
+	// Ignore lists gitignore style patterns of files and directories to
+	// skip.
+	Ignore []string `json:"ignore"`
+	// IgnoreFiles lists more ignore files read in every directory along
+	// with .f5ignore, such as .gitignore, .ignore and .rgignore. Patterns
+	// of a deeper file take precedence, and in the same directory, those
+	// of a later file, with .f5ignore last.
+	IgnoreFiles []string `json:"ignore-files"`
+	// EnvFile, if set, is a file of KEY=VALUE lines added to the command's
+	// environment, unless f5's own environment sets them. It is reread on
+	// every restart, and changes to it restart the command. It may only be
+	// missing when it is DefaultEnvFile.
+	EnvFile string `json:"env-file"`
+	// ConfigFile, if set, is the file the settings were read from. It is
+	// watched, and when it changes the runner shuts down with Reloading
+	// reporting true, for the caller to start again with the new settings.
+	ConfigFile string `json:"-"`
+	// CleanEnv runs the command with only the variables of f5's
+	// environment listed in EnvKeep, such as PATH and HOME, and those of
+	// EnvFile, rather than all of them. Without PATH, most commands cannot
+	// run the programs they need.
+	CleanEnv bool     `json:"clean-env"`
+	EnvKeep  []string `json:"env-keep"`
+	// RunEnv tells the command about its run in its environment, as
+	// F5_RUN, the number of the run counting from 1, F5_REASON, why it was
+	// started, one of start, key, change, delete, signal, control,
+	// trigger-cmd and git, and F5_TRIGGER, the file whose change started
+	// it, if any.
+	RunEnv bool `json:"run-env"`
+	// PortEnv, if set, is the name of an environment variable set to a
+	// free TCP port on each start, such as PORT, so that a restarted server
+	// does not wait for the port of the previous run to be released. The
+	// command must listen on the port given there.
+	PortEnv string `json:"port-env"`
+	// BlueGreen keeps the previous run going on a restart until the new
+	// one, listening on its own PortEnv port, is ready as told by
+	// ReadyRegex, so that a server is never down while restarting. If the
+	// new run exits before being ready, the previous one goes on as the
+	// current run. It is stopped anyway if the new one is not ready within
+	// ReadyTimeout, or 30s if unset.
+	BlueGreen bool `json:"blue-green"`
+	// PIDFile, if set, is kept up to date with the pid of the running
+	// command, and removed once the command exits and on Close.
+	PIDFile string `json:"pid-file"`
+	// User and Group, if set, are the names or ids of the user and group
+	// to run the command as, which needs f5 to run as root. The group
+	// defaults to the primary group of the user.
+	User  string `json:"user"`
+	Group string `json:"group"`
+	// Limits sets resource limits of the command, from resource to value,
+	// such as "nofile": "1024" or "as": "512MB". The resources are as,
+	// core, data, fsize and stack, sizes in bytes with an optional K, M or
+	// G suffix, cpu in seconds, nofile and nproc. A value may be
+	// "unlimited". The limits are set by f5 run again as a helper, which
+	// then runs the command in its place; see RunHelper.
+	Limits map[string]string `json:"limit"`
+	// GoStatus, for a go run command without its own -exec, runs the
+	// program built through f5 as go run -exec, to report the exit code of
+	// the program rather than that of go run, which is 1 whatever the
+	// program exited with.
+	GoStatus bool `json:"go-status"`
+	// ForceAfter is how long the command has to exit once interrupted on
+	// restart or shutdown, before it is killed, DefaultForceAfter when
+	// zero. NoForce never kills it, and warns while it keeps running.
+	ForceAfter time.Duration `json:"force-after"`
+	NoForce    bool          `json:"no-force"`
+	// NoProcessGroup runs the command in f5's process group instead of its
+	// own, for programs that misbehave in a new group. Only the command
+	// itself is signaled then, so processes it spawns may be orphaned.
+	NoProcessGroup bool `json:"no-pgid"`
+	// TriggerCommand, if set, is a shell command run every
+	// TriggerInterval, restarting the command when its output changes.
+	TriggerCommand  string        `json:"trigger-cmd"`
+	TriggerInterval time.Duration `json:"trigger-interval"`
+	// Wrap, if set, is a space separated command the command is run
+	// under, such as "/usr/bin/time -v". The wrapper runs in the
+	// command's process group, so it is stopped along with the command.
+	Wrap string `json:"wrap"`
+	// Build, if set, is a shell command run before each start, which is
+	// skipped while the previous run goes on when the build fails.
+	// RebuildExt limits building to restarts for changes to files with
+	// these extensions; other changes restart without building.
+	Build      string   `json:"build"`
+	RebuildExt []string `json:"rebuild-ext"`
+	// ReloadExt lists extensions of files whose changes send the command
+	// ReloadSignal, SIGHUP by default, instead of restarting it, such as
+	// templates the command reloads on its own. Changes coalesced while a
+	// restart is under way restart the command if any of them would.
+	ReloadExt    []string `json:"reload-ext"`
+	ReloadSignal string   `json:"reload-signal"`
+	// OnStart, if set, is a shell command run after each start of the
+	// command, such as a smoke test, which the restart waits for before
+	// the command can be reported ready, for at most OnStartTimeout unless
+	// it is zero. The command keeps running when it fails.
+	OnStart        string        `json:"on-start"`
+	OnStartTimeout time.Duration `json:"on-start-timeout"`
+	// LockFile, if set, is a file whose existence holds off restarting,
+	// such as one created by a code generator while it runs. The restarts
+	// wait for it to be removed, for at most LockTimeout unless it is zero,
+	// then restart once.
+	LockFile    string        `json:"lock-file"`
+	LockTimeout time.Duration `json:"lock-timeout"`
+	// Cleanup, if set, is a shell command run on Close after the command
+	// was stopped, such as "docker compose down".
+	Cleanup string `json:"cleanup"`
+	// EchoCommand prints the command as a shell command line on each
+	// start, to paste and run it outside of f5.
+	EchoCommand bool `json:"echo-cmd"`
+	// IdleStop, if set, stops the command when no change or key press
+	// restarted it, or sent it the ReloadSignal, for this long, to save
+	// resources, and starts it again on the next one.
+	IdleStop time.Duration `json:"idle-stop"`
+	// Clear clears the screen before each restart, but not before the
+	// first run, so the startup messages can still be read.
+	Clear bool `json:"clear"`
+	// GroupOutput brackets the output of each run between a begin and an
+	// end banner, with its exit status and duration.
+	GroupOutput bool `json:"group-output"`
+	// Socket, if set, is the path of a Unix socket accepting the control
+	// commands "restart", "status" and "quit", one per line, and streaming
+	// events back as JSON lines. It is removed on Close.
+	Socket string `json:"sock"`
+	// Listen, if set, is the address of an HTTP server serving, when
+	// Metrics is set, restart and uptime counters on /metrics in the
+	// Prometheus text format.
+	Listen  string `json:"listen"`
+	Metrics bool   `json:"metrics"`
+	// ExtColors overrides the colors of changed file names by extension,
+	// such as ".go": "cyan". The colors are black, red, green, yellow,
+	// blue, magenta, cyan, white and gray.
+	ExtColors map[string]string `json:"ext-color"`
+	// EventHook, if set, is a shell command run for as long as f5 runs,
+	// and started again if it exits, which reads the events on its
+	// standard input as JSON lines, as streamed to Socket clients:
+	//
+	//	{"time": "2006-01-02T15:04:05Z", "type": "start", "pid": 42, "run": 1, "path": "main.go", "message": "change"}
+	//
+	// where type is "start", "ready", "exit", "change" or "error", and
+	// "code" is the exit code of an "exit" event; see Event.
+	EventHook string `json:"event-hook"`
+	// Notifiers are told about restarts, exits and errors, in order, such
+	// as DesktopNotifier and WebhookNotifier.
+	Notifiers []Notifier `json:"-"`
+	// Confirm lists the reasons to restart that are asked for first on the
+	// terminal, such as "key" or "change", or "all" of them, for commands
+	// too costly to run by accident. No answer within ConfirmTimeout,
+	// unless it is zero, means no.
+	Confirm        []string      `json:"confirm"`
+	ConfirmTimeout time.Duration `json:"confirm-timeout"`
+	// RestartOnExit lists exit codes after which the command is started
+	// again right away, as for a program asking to be reloaded, rather
+	// than waiting for a change. Starts are a second apart at least.
+	RestartOnExit []int `json:"restart-on-exit"`
+	// Count, if set, is the number of restarts after which f5 quits
+	// rather than restarting again.
+	Count int `json:"count"`
+	// SpaceKey is what the space key does: "restart", as F5 and Ctrl-R
+	// do, which is the default, "pause", as p does, or "none", for those
+	// who hit it by accident.
+	SpaceKey string `json:"space-key"`
+	// KeySignals binds keys to signals sent to the running command without
+	// restarting it, such as "1": "USR1". Keys f5 uses itself cannot be
+	// bound.
+	KeySignals map[string]string `json:"key-signal"`
+	// ReadyRegex, if set, is matched against each line of output of the
+	// command, which is reported ready on the first match, and OnReady run
+	// then as a shell command. A warning is printed if the command is not
+	// ready within ReadyTimeout, unless it is zero.
+	ReadyRegex   string        `json:"ready-regex"`
+	ReadyTimeout time.Duration `json:"ready-timeout"`
+	OnReady      string        `json:"on-ready"`
+	// WarmupURL, if set, is requested once the command is ready, with
+	// WarmupMethod, GET by default, and WarmupBody, to prime a server
+	// before it is used. Without ReadyRegex, it is requested until the
+	// server answers.
+	WarmupURL    string `json:"warmup-url"`
+	WarmupMethod string `json:"warmup-method"`
+	WarmupBody   string `json:"warmup-body"`
+	// Expect, if set, runs the command once and quits when its output
+	// contains this string. ExitCode is ExitNotFound when the command
+	// exits, or ExpectTimeout passes, before it is found. A zero
+	// ExpectTimeout waits for as long as the command runs.
+	Expect        string        `json:"expect"`
+	ExpectTimeout time.Duration `json:"expect-timeout"`
+	// Replay, if set, keeps this many of the last lines of output of the
+	// previous run, shown again by pressing o. The output of the command
+	// is piped through f5 then, rather than written to the terminal.
+	Replay int `json:"replay"`
+	// Summary prints a recap of the session on shutdown: the restarts and
+	// crashes, how long f5 ran and the files changed most often.
+	Summary bool `json:"summary"`
+	// Label, if set, replaces the [Press F5 to refresh "cmd"] prefix of
+	// f5's output, to tell several instances apart.
+	Label string `json:"label"`
+	// Theme is the name of the color theme, one of Themes(). It defaults
+	// to "default".
+	Theme string `json:"theme"`
+	// Color is when to color f5's output: "auto", the default, when its
+	// error output is a terminal and NO_COLOR is not set, "always", even
+	// when piped or NO_COLOR is set, or "never", as with the mono theme.
+	Color string `json:"color"`
+	// TUI shows a full screen panel with the status of the command and its
+	// recent output, instead of writing to the terminal directly.
+	TUI bool `json:"tui"`
+	// Stdin passes f5's standard input to the command. When it is a
+	// terminal, the command has it in the foreground and in its usual mode
+	// while it runs, as an interactive program expects, and f5 reads keys
+	// only while the command is not running.
+	Stdin bool `json:"stdin"`
+	// PTY runs the command on a pseudo-terminal, for programs that behave
+	// differently when their output is not a terminal. Its output and
+	// error output are then a single stream.
+	PTY bool `json:"pty"`
+	// TimestampOutput prefixes each line of output of the command with the
+	// time it was written, as [15:04:05.000], or in the time.Format layout
+	// TimestampFormat, if set.
+	TimestampOutput bool   `json:"ts-output"`
+	TimestampFormat string `json:"ts-format"`
+	// Tmux runs the command in a new pane of the tmux window f5 runs in,
+	// for programs with a full screen interface, while f5's messages stay
+	// in its own pane. The pane is removed on Close. The command's output
+	// goes only to the pane then. Outside of tmux, the command runs as
+	// usual.
+	Tmux bool `json:"tmux"`
+	// MergeOutput sends the error output of the command to where its
+	// output goes, interleaved in order, instead of keeping it a separate
+	// stream.
+	MergeOutput bool `json:"merge-output"`
+	// Tee, if set, is a file the output of the command is copied to, started
+	// anew on each restart, or appended to across restarts with TeeAppend.
+	Tee       string `json:"tee"`
+	TeeAppend bool   `json:"tee-append"`
+	// MaxOutputRate, if set, limits the output of the command shown to
+	// this many lines a second, dropping the rest with a notice, so a
+	// runaway command cannot flood the terminal. f5 then copies the output
+	// rather than passing the terminal on to the command.
+	MaxOutputRate int `json:"max-output-rate"`
+	// MaxOutputBytes, if set, bounds the output f5 holds in memory: the
+	// longest partial line buffered, and the output kept for Replay.
+	MaxOutputBytes int `json:"max-output-bytes"`
+	// LineBuffered passes the output of the command on line by line,
+	// flushing partial lines after a short delay, instead of copying it
+	// to the terminal as is.
+	LineBuffered bool `json:"line-buffered"`
+}
+
+// Resolved returns the config with the defaults applied by NewWithConfig
+// filled in, such as the working directory as root.
+func (c Config) Resolved() Config {
+	if len(c.Roots) == 0 {
+		c.Roots = []Root{{Dir: "."}}
+	}
+	if c.Color == "" {
+		c.Color = "auto"
+	}
+	if c.WarmupMethod == "" {
+		c.WarmupMethod = http.MethodGet
+	}
+	if c.ReloadSignal == "" {
+		c.ReloadSignal = "HUP"
+	}
+	c.Theme = themeName(c.Theme, c.Color)
+	return c
+}
+
+// MarshalJSON encodes the config with the names of the flags of the f5
+// command, durations as strings such as "2s", and the resulting
+// "watched-extensions" added.
+func (c Config) MarshalJSON() ([]byte, error) {
+	type plain Config
+	b, err := json.Marshal(plain(c))
+	if err != nil {
+		return nil, err
+	}
+	m := map[string]any{}
+	if err := json.Unmarshal(b, &m); err != nil {
+		return nil, err
+	}
+	v := reflect.ValueOf(c)
+	for i := 0; i < v.NumField(); i++ {
+		if d, ok := v.Field(i).Interface().(time.Duration); ok {
+			name, _, _ := strings.Cut(v.Type().Field(i).Tag.Get("json"), ",")
+			m[name] = d.String()
+		}
+	}
+	m["watched-extensions"] = c.WatchedExtensions()
+	return json.Marshal(m)
+}
+
+// Root is a directory tree watched for changes, with its own filter. The
+// roots share the command run.
+type Root struct {
+	// Dir is the directory to watch, relative to the working directory
+	// unless absolute.
+	Dir string `json:"dir"`
+	// Extensions replaces the default extensions watched under Dir.
+	Extensions []string `json:"ext,omitempty"`
+	// Ignore lists gitignore style patterns of files and directories to
+	// skip, relative to Dir.
+	Ignore []string `json:"ignore,omitempty"`
+}
+
+// ParseRoot parses a root of the form "dir[:key=value...]", for example
+// "frontend:ext=.ts,.tsx:ignore=node_modules". The keys are "ext" and
+// "ignore", each taking a comma separated list.
+func ParseRoot(s string) (Root, error) {
+	parts := strings.Split(s, ":")
+	root := Root{Dir: parts[0]}
+	if root.Dir == "" {
+		return root, fmt.Errorf("missing directory in %q", s)
+	}
+	for _, p := range parts[1:] {
+		key, value, ok := strings.Cut(p, "=")
+		if !ok {
+			return root, fmt.Errorf("expect key=value, got %q", p)
+		}
+		list := strings.Split(value, ",")
+		switch key {
+		case "ext":
+			root.Extensions = append(root.Extensions, list...)
+		case "ignore":
+			root.Ignore = append(root.Ignore, list...)
+		default:
+			return root, fmt.Errorf("unknown key %q", key)
+		}
+	}
+	return root, nil
+}
+
+// WatchedExtensions returns the default extensions with the adjustments of
+// c.Extensions applied.
+func (c Config) WatchedExtensions() []string {
+	exts := DefaultExtensions()
+	for _, e := range c.Extensions {
+		if strings.HasPrefix(e, "-") {
+			e = normalizeExt(e[1:])
+			for i, x := range exts {
+				if x == e {
+					exts = append(exts[:i], exts[i+1:]...)
+					break
+				}
+			}
+			continue
+		}
+		e = normalizeExt(e)
+		found := false
+		for _, x := range exts {
+			found = found || x == e
+		}
+		if !found {
+			exts = append(exts, e)
+		}
+	}
+	return exts
+}
@@ -0,0 +1,92 @@
+package f5
+
+import (
+	"context"
+	"fmt"
+	"strings"
+	"time"
+)
+
+// confirmReasons are the reasons to restart -confirm takes.
+var confirmReasons = []string{"key", "change", "delete", "signal", "control", "trigger-cmd", "git", "exit", "all"}
+
+// checkConfirm checks the reasons given to -confirm.
+func checkConfirm(reasons []string) error {
+	for _, reason := range reasons {
+		known := false
+		for _, k := range confirmReasons {
+			known = known || k == reason
+		}
+		if !known {
+			return fmt.Errorf("unknown -confirm reason %q, expect one of %s", reason, strings.Join(confirmReasons, ", "))
+		}
+	}
+	return nil
+}
+
+// needsConfirm reports whether restarting for t is asked for first, as
+// set by -confirm. The first start never is.
+func (r *Run) needsConfirm(t trigger) bool {
+	if t.reason == "start" {
+		return false
+	}
+	for _, reason := range r.cfg.Confirm {
+		if reason == "all" || reason == t.reason {
+			return true
+		}
+	}
+	return false
+}
+
+// confirmed asks whether to restart for t, when it needs to be, and
+// reports the answer. Anything but y, no answer within -confirm-timeout
+// and no terminal to ask on all mean no. The restarts queued meanwhile are
+// dropped either way, as they are answered along with t.
+func (r *Run) confirmed(ctx context.Context, t trigger) bool {
+	if !r.needsConfirm(t) {
+		return true
+	}
+	if !r.Interactive() {
+		r.printf(colorWarn, "Not restarting for %s: -confirm needs a terminal to ask on", t.reason)
+		return false
+	}
+	r.asking.Lock()
+	defer r.asking.Unlock()
+	what := t.reason
+	if t.path != "" {
+		what += " of " + t.path
+	}
+	var timeout <-chan time.Time
+	if r.cfg.ConfirmTimeout == 0 {
+		r.printf(colorAccent, "Restart for %s? (y/N)", what)
+	} else {
+		r.printf(colorAccent, "Restart for %s? (y/N, no in %s)", what, r.cfg.ConfirmTimeout)
+		timer := time.NewTimer(r.cfg.ConfirmTimeout)
+		defer timer.Stop()
+		timeout = timer.C
+	}
+	yes := false
+	select {
+	case <-ctx.Done():
+		return false
+	case <-timeout:
+	case key := <-r.answers:
+		yes = strings.EqualFold(key, "y")
+	}
+	r.drain()
+	if !yes {
+		r.printf(colorWarn, "Not restarting")
+	}
+	return yes
+}
+
+// answer passes the key to a pending prompt, and reports whether there was
+// one.
+func (r *Run) answer(key string) bool {
+	select {
+	case r.answers <- key:
+		return true
+	default:
+		return false
+	}
+}
@@ -0,0 +1,126 @@
+package f5
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"fmt"
+	"net"
+	"os"
+	"strings"
+	"time"
+)
+
+// control runs a control command, as sent through the control socket, and
+// returns the reply. A restart is queued as file changes are, and its start
+// follows as an event.
+func (r *Run) control(name string) (Event, error) {
+	reply := Event{Type: "reply"}
+	switch name {
+	case "restart":
+		r.send(trigger{reason: "control"})
+		reply.Message = "restarting"
+	case "status":
+		s := r.State()
+		reply.Run, reply.PID, reply.Code = s.Runs, s.PID, s.ExitCode
+		reply.Message = s.Status
+		if s.Paused {
+			reply.Message += ", paused"
+		}
+	case "quit":
+		r.Quit()
+		reply.Message = "quitting"
+	default:
+		return reply, fmt.Errorf("unknown command %q, expect restart, status or quit", name)
+	}
+	return reply, nil
+}
+
+// listen serves the control socket until ctx is done. Clients send one
+// command per line, and receive the replies and all events as JSON lines.
+func (r *Run) listen(ctx context.Context) error {
+	if r.cfg.Socket == "" {
+		return nil
+	}
+	// remove a socket left over by an f5 that did not shut down cleanly.
+	if fi, err := os.Stat(r.cfg.Socket); err == nil && fi.Mode()&os.ModeSocket != 0 {
+		os.Remove(r.cfg.Socket)
+	}
+	l, err := net.Listen("unix", r.cfg.Socket)
+	if err != nil {
+		return err
+	}
+	r.sock = l
+	r.usagef(colorInfo, "Listening for commands on %s", r.cfg.Socket)
+	go func() {
+		<-ctx.Done()
+		l.Close()
+	}()
+	r.goroutine(func() {
+		for {
+			conn, err := l.Accept()
+			if err != nil {
+				return
+			}
+			r.goroutine(func() { r.serve(ctx, conn) })
+		}
+	})
+	return nil
+}
+
+func (r *Run) serve(ctx context.Context, conn net.Conn) {
+	defer conn.Close()
+	events, stop := r.events.subscribe()
+	defer stop()
+	replies := make(chan Event)
+	// done stops the reader from waiting to send a reply once serve
+	// returned, as it does when ctx is done or the client went away.
+	done := make(chan struct{})
+	defer close(done)
+	go func() {
+		defer close(replies)
+		s := bufio.NewScanner(conn)
+		for s.Scan() {
+			name := strings.TrimSpace(s.Text())
+			if name == "" {
+				continue
+			}
+			reply, err := r.control(name)
+			if err != nil {
+				reply.Type, reply.Message = "error", err.Error()
+			}
+			reply.Time = time.Now()
+			select {
+			case replies <- reply:
+			case <-done:
+				return
+			}
+		}
+	}()
+	enc := json.NewEncoder(conn)
+	for {
+		var e Event
+		var ok bool
+		select {
+		case <-ctx.Done():
+			return
+		case e, ok = <-replies:
+			if !ok {
+				return
+			}
+		case e = <-events:
+		}
+		if err := enc.Encode(e); err != nil {
+			return
+		}
+	}
+}
+
+// closeSocket stops listening and removes the socket file.
+func (r *Run) closeSocket() {
+	if r.sock == nil {
+		return
+	}
+	r.sock.Close()
+	os.Remove(r.cfg.Socket)
+}
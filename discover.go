@@ -0,0 +1,323 @@
+package f5
+
+import (
+	"errors"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"sort"
+	"strings"
+	"sync"
+	"syscall"
+)
+
+// discoverWorkers bounds the number of directories read at once when
+// looking for watched files.
+const discoverWorkers = 8
+
+// discover walks the tree at dir inside the root, and returns the
+// directories worth watching: those with a watched file in them, or all of
+// them when all is set. Directories that cannot be read are skipped, and
+// returned as errors.
+func (r *Run) discover(rt *root, dir string, all bool) ([]string, []error) {
+	return r.rediscover(rt, dir, all, nil)
+}
+
+// rediscover is discover for a tree discovered before: the directories of
+// the previous set still in the tree are kept without reading them again,
+// so only directories added since are looked into. A previous directory
+// whose watched files were all removed is kept.
+func (r *Run) rediscover(rt *root, dir string, all bool, previous map[string]bool) ([]string, []error) {
+	dirs := []string{}
+	errs := []error{}
+	tooDeep := 0
+	filepath.WalkDir(dir, func(s string, d fs.DirEntry, err error) error {
+		if err != nil {
+			// skip the subtree rather than stopping the walk, and drop the
+			// directory if it was visited before failing to be read.
+			errs = append(errs, err)
+			if n := len(dirs); n > 0 && dirs[n-1] == s {
+				dirs = dirs[:n-1]
+			}
+			if d != nil && d.IsDir() {
+				return filepath.SkipDir
+			}
+			return nil
+		}
+		if !d.IsDir() {
+			return nil
+		}
+		// skip hidden directories with . as prefix
+		if s != rt.dir && strings.HasPrefix(filepath.Base(s), ".") {
+			return filepath.SkipDir
+		}
+		if rt.ignored(s, true) {
+			return filepath.SkipDir
+		}
+		if r.cfg.MaxDepth != nil && depth(rt, s) > *r.cfg.MaxDepth {
+			tooDeep++
+			return filepath.SkipDir
+		}
+		watch, descend := r.allowed(s)
+		if !descend {
+			return filepath.SkipDir
+		}
+		if err := rt.loadIgnore(s); err != nil {
+			errs = append(errs, err)
+		}
+		if watch {
+			dirs = append(dirs, s)
+		}
+		return nil
+	})
+	if tooDeep > 0 {
+		r.debugf("skipped %d directories below -max-depth %d in %s", tooDeep, *r.cfg.MaxDepth, dir)
+	}
+	if all {
+		return dirs, errs
+	}
+	// reading the directories dominates on large trees, so read several at
+	// once, keeping the order of the walk.
+	keep := make([]bool, len(dirs))
+	failed := make([]error, len(dirs))
+	next := make(chan int)
+	var wg sync.WaitGroup
+	for w := 0; w < discoverWorkers; w++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := range next {
+				keep[i], failed[i] = hasWatched(rt, dirs[i])
+			}
+		}()
+	}
+	for i, d := range dirs {
+		if previous[d] {
+			keep[i] = true
+			continue
+		}
+		next <- i
+	}
+	close(next)
+	wg.Wait()
+	found := []string{}
+	for i, d := range dirs {
+		if keep[i] {
+			found = append(found, d)
+		}
+		if failed[i] != nil {
+			errs = append(errs, failed[i])
+		}
+	}
+	return found, errs
+}
+
+// depth returns the number of levels of the directory below the root, 0
+// for the root itself.
+func depth(rt *root, dir string) int {
+	rel := rt.rel(dir)
+	if rel == "" {
+		return 0
+	}
+	return strings.Count(rel, "/") + 1
+}
+
+// hasWatched reports whether the directory is worth watching, as decided
+// by the root's predicate if it has one.
+func hasWatched(rt *root, dir string) (bool, error) {
+	files, err := os.ReadDir(dir)
+	if rt.watchDir != nil {
+		return rt.watchDir(dir, files), err
+	}
+	for _, f := range files {
+		path := filepath.Join(dir, f.Name())
+		if !f.IsDir() && rt.supported(path) && !rt.ignored(path, false) {
+			return true, nil
+		}
+	}
+	return false, err
+}
+
+// watchSet returns the directories worth watching in all roots and of the
+// git repositories watched, and the single files watched, including the
+// environment and config files, sorted by path, after reporting the parts
+// of the trees that cannot be read. Directories in previous are
+// taken as worth watching, as by rediscover.
+func (r *Run) watchSet(previous map[string]bool) (dirs, files []string) {
+	dirs = []string{}
+	for _, rt := range r.roots {
+		if rt.file != "" {
+			files = append(files, rt.file)
+			continue
+		}
+		found, errs := r.rediscover(rt, rt.dir, false, previous)
+		r.skipped(errs)
+		dirs = append(dirs, found...)
+	}
+	for _, g := range r.gits {
+		dirs = append(dirs, g.dirs()...)
+	}
+	if r.envFile != "" {
+		files = append(files, r.envFile)
+	}
+	if r.configFile != "" {
+		files = append(files, r.configFile)
+	}
+	dirs, files = unique(dirs), unique(files)
+	// sorted, for the listing to be the same on every run.
+	sort.Strings(dirs)
+	sort.Strings(files)
+	return dirs, files
+}
+
+// rewatch discovers the directories to watch again, for when the tree
+// drifted from the one found at startup. Only the changes to the watched
+// set are applied, so the directories still watched are not read again.
+func (r *Run) rewatch() {
+	r.watching.Lock()
+	defer r.watching.Unlock()
+	previous := r.watchedDirs()
+	dirs, files := r.watchSet(previous)
+	want := map[string]bool{}
+	for _, d := range dirs {
+		want[d] = true
+	}
+	for _, f := range files {
+		want[filepath.Dir(f)] = true
+	}
+	// remove before adding, as a directory moved since keeps its watch
+	// under the old name.
+	removed, added := 0, 0
+	for name := range previous {
+		if !want[name] {
+			r.unwatch(name)
+			removed++
+		}
+	}
+	for d := range want {
+		if !previous[d] {
+			r.add(d)
+			added++
+		}
+	}
+	r.printf(colorInfo, "Rediscovered %d directories to monitor, %d added and %d removed.", len(want), added, removed)
+}
+
+// watchNew watches a directory created while f5 runs, and the directories
+// in it, as files may be added to them later.
+func (r *Run) watchNew(dir string) {
+	rt := r.rootOf(dir)
+	if rt == nil {
+		return
+	}
+	r.watching.Lock()
+	defer r.watching.Unlock()
+	found, errs := r.discover(rt, dir, true)
+	r.skipped(errs)
+	for _, d := range found {
+		r.debugf("watching new directory %s", d)
+		r.add(d)
+	}
+}
+
+// unwatchMoved stops watching the directory renamed away from path and
+// those inside it, whose watches would otherwise stay under their old
+// names. If it is still in a root, it is found again under its new name by
+// watchNew, as a directory created there.
+func (r *Run) unwatchMoved(path string) {
+	r.watching.Lock()
+	defer r.watching.Unlock()
+	for name := range r.watchedDirs() {
+		if within(path, name) {
+			r.debugf("not watching moved directory %s", name)
+			r.unwatch(name)
+		}
+	}
+}
+
+// unwatchRemoved forgets the directory removed at path, if it was watched,
+// so that it is watched again when created anew.
+func (r *Run) unwatchRemoved(path string) {
+	r.mu.Lock()
+	watched := r.watched[path]
+	r.mu.Unlock()
+	if watched {
+		r.debugf("not watching removed directory %s", path)
+		r.unwatch(path)
+	}
+}
+
+// watchedDirs returns the directories watched or polled.
+func (r *Run) watchedDirs() map[string]bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	dirs := make(map[string]bool, len(r.watched))
+	for d := range r.watched {
+		dirs[d] = true
+	}
+	return dirs
+}
+
+// unwatch stops watching or polling the directory.
+func (r *Run) unwatch(dir string) {
+	r.watcher.Remove(dir)
+	r.poller.remove(dir)
+	r.mu.Lock()
+	delete(r.watched, dir)
+	r.mu.Unlock()
+}
+
+// add watches the directory, falling back to polling it when the system
+// is out of inotify watches. A directory already watched, as found in
+// overlapping roots, is left as is.
+func (r *Run) add(dir string) {
+	r.mu.Lock()
+	watched := r.watched[dir]
+	r.watched[dir] = true
+	r.mu.Unlock()
+	if watched {
+		return
+	}
+	err := r.watcher.Add(dir)
+	if errors.Is(err, syscall.ENOSPC) {
+		r.warnWatchLimit.Do(func() {
+			r.printf(colorWarn, "Out of inotify watches, polling directories that cannot be watched instead.")
+			r.printf(colorWarn, "To raise the limit, run: sudo sysctl fs.inotify.max_user_watches=524288")
+		})
+		r.debugf("polling %s", dir)
+		r.poller.add(dir)
+		return
+	}
+	if err != nil {
+		r.printf(colorError, "Cannot watch %s: %v", dir, err)
+		r.mu.Lock()
+		delete(r.watched, dir)
+		r.mu.Unlock()
+	}
+}
+
+// skipped reports the directories discovery could not read.
+func (r *Run) skipped(errs []error) {
+	for _, err := range errs {
+		r.printf(colorWarn, "Not watching what cannot be read: %v", err)
+	}
+}
+
+// unique returns the names without repeats, in order, as roots may
+// overlap.
+func unique(names []string) []string {
+	seen := map[string]bool{}
+	kept := names[:0]
+	for _, n := range names {
+		if !seen[n] {
+			seen[n] = true
+			kept = append(kept, n)
+		}
+	}
+	return kept
+}
+
+func isDir(path string) bool {
+	fi, err := os.Stat(path)
+	return err == nil && fi.IsDir()
+}
@@ -0,0 +1,241 @@
+package f5
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"sort"
+	"strconv"
+	"testing"
+)
+
+// newTestRun returns a runner of true with cfg, closing its watcher when
+// the test ends.
+func newTestRun(t testing.TB, cfg Config) *Run {
+	t.Helper()
+	r, err := NewWithConfig(cfg, "true")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.watcher.Close() })
+	return r
+}
+
+// mkdirs creates the directories in dir.
+func mkdirs(t testing.TB, dir string, paths ...string) {
+	t.Helper()
+	for _, p := range paths {
+		if err := os.MkdirAll(filepath.Join(dir, p), 0o755); err != nil {
+			t.Fatal(err)
+		}
+	}
+}
+
+func TestDepth(t *testing.T) {
+	rt := &root{dir: "/src"}
+	tests := map[string]int{
+		"/src":       0,
+		"/src/a":     1,
+		"/src/a/b":   2,
+		"/src/a/b/c": 3,
+	}
+	for dir, want := range tests {
+		if got := depth(rt, dir); got != want {
+			t.Errorf("depth(%s) = %d, want %d", dir, got, want)
+		}
+	}
+}
+
+func TestMaxDepth(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "a/b/c", "d")
+	tests := []struct {
+		max  *int
+		want []string
+	}{
+		{max: intp(0), want: []string{""}},
+		{max: intp(1), want: []string{"", "a", "d"}},
+		{max: intp(2), want: []string{"", "a", "a/b", "d"}},
+		{max: nil, want: []string{"", "a", "a/b", "a/b/c", "d"}},
+	}
+	for _, tt := range tests {
+		r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}, MaxDepth: tt.max})
+		rt := r.roots[0]
+		dirs, errs := r.rediscover(rt, rt.dir, true, nil)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		got := []string{}
+		for _, d := range dirs {
+			got = append(got, rt.rel(d))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			max := "unlimited"
+			if tt.max != nil {
+				max = strconv.Itoa(*tt.max)
+			}
+			t.Errorf("-max-depth %s watches %q, want %q", max, got, tt.want)
+		}
+	}
+}
+
+func intp(n int) *int { return &n }
+
+// makeTree creates width directories in dir, nested depth levels deep,
+// with a Go file in every other one.
+func makeTree(t testing.TB, dir string, width, depth int) {
+	t.Helper()
+	for i := 0; i < width; i++ {
+		sub := filepath.Join(dir, "d"+strconv.Itoa(i))
+		mkdirs(t, sub)
+		if i%2 == 0 {
+			writeFile(t, sub, "main.go", "package main\n")
+		} else {
+			writeFile(t, sub, "README", "")
+		}
+		if depth > 1 {
+			makeTree(t, sub, width, depth-1)
+		}
+	}
+}
+
+func TestDiscover(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, 3, 2)
+	r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}})
+	rt := r.roots[0]
+	dirs, errs := r.discover(rt, rt.dir, false)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := []string{}
+	for _, d := range dirs {
+		got = append(got, rt.rel(d))
+	}
+	// in the order of the walk, without the directories with no Go file.
+	want := []string{"d0", "d0/d0", "d0/d2", "d1/d0", "d1/d2", "d2", "d2/d0", "d2/d2"}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("discovered %q, want %q", got, want)
+	}
+}
+
+// BenchmarkDiscover measures discovering a tree of 1,884 directories, most
+// of the time going to reading them.
+func BenchmarkDiscover(b *testing.B) {
+	dir := b.TempDir()
+	makeTree(b, dir, 12, 3)
+	r := newTestRun(b, Config{Roots: []Root{{Dir: dir}}})
+	rt := r.roots[0]
+	b.ResetTimer()
+	for i := 0; i < b.N; i++ {
+		if _, errs := r.discover(rt, rt.dir, false); len(errs) > 0 {
+			b.Fatal(errs)
+		}
+	}
+}
+
+func TestRediscover(t *testing.T) {
+	dir := t.TempDir()
+	makeTree(t, dir, 2, 1)
+	r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}})
+	rt := r.roots[0]
+	previous := map[string]bool{}
+	dirs, _ := r.discover(rt, rt.dir, false)
+	for _, d := range dirs {
+		previous[d] = true
+	}
+	// d0 lost its watched file, d2 was added.
+	os.Remove(filepath.Join(dir, "d0", "main.go"))
+	writeFile(t, dir, "d2/main.go", "package main\n")
+	dirs, errs := r.rediscover(rt, rt.dir, false, previous)
+	if len(errs) > 0 {
+		t.Fatal(errs)
+	}
+	got := []string{}
+	for _, d := range dirs {
+		got = append(got, rt.rel(d))
+	}
+	if want := []string{"d0", "d2"}; !reflect.DeepEqual(got, want) {
+		t.Errorf("rediscovered %q, want %q", got, want)
+	}
+}
+
+// BenchmarkRediscover compares discovering the tree of BenchmarkDiscover
+// with rediscovering it, when it did not change.
+func BenchmarkRediscover(b *testing.B) {
+	dir := b.TempDir()
+	makeTree(b, dir, 12, 3)
+	r := newTestRun(b, Config{Roots: []Root{{Dir: dir}}})
+	rt := r.roots[0]
+	dirs, _ := r.discover(rt, rt.dir, false)
+	previous := map[string]bool{}
+	for _, d := range dirs {
+		previous[d] = true
+	}
+	for _, bb := range []struct {
+		name     string
+		previous map[string]bool
+	}{
+		{"full", nil},
+		{"incremental", previous},
+	} {
+		b.Run(bb.name, func(b *testing.B) {
+			for i := 0; i < b.N; i++ {
+				if _, errs := r.rediscover(rt, rt.dir, false, bb.previous); len(errs) > 0 {
+					b.Fatal(errs)
+				}
+			}
+		})
+	}
+}
+
+func TestRenameWatchedDir(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "main.go", "package main\n")
+	writeFile(t, dir, "old/a.go", "package a\n")
+	writeFile(t, dir, "old/sub/b.go", "package sub\n")
+	r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}})
+	got := watchRestarts(t, r, func() {
+		os.Rename(filepath.Join(dir, "old"), filepath.Join(dir, "new"))
+	})
+	if len(got) > 0 {
+		t.Errorf("restarts %+v for a renamed directory, want none", got)
+	}
+	watched := []string{}
+	for d := range r.watchedDirs() {
+		watched = append(watched, r.roots[0].rel(d))
+	}
+	sort.Strings(watched)
+	if want := []string{"", "new", "new/sub"}; !reflect.DeepEqual(watched, want) {
+		t.Errorf("watching %q after the rename, want %q", watched, want)
+	}
+	// changes under the new name restart the command.
+	writeFile(t, dir, "new/sub/b.go", "package sub // changed\n")
+	got = restarts(r)
+	if len(got) == 0 || got[0].path != filepath.Join(dir, "new/sub/b.go") {
+		t.Errorf("restarts %+v, want one for new/sub/b.go", got)
+	}
+}
+
+func TestOverlappingRoots(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "main.go", "package main\n")
+	file := writeFile(t, dir, "sub/a.go", "package sub\n")
+	r := newTestRun(t, Config{Roots: []Root{{Dir: dir}, {Dir: filepath.Join(dir, "sub")}, {Dir: dir}}})
+	dirs, _ := r.watchSet(nil)
+	if want := []string{dir, filepath.Join(dir, "sub")}; !reflect.DeepEqual(dirs, want) {
+		t.Errorf("watching %q, want %q", dirs, want)
+	}
+	got := watchRestarts(t, r, func() {
+		// in a single write, which is a single event.
+		f, err := os.OpenFile(file, os.O_WRONLY|os.O_APPEND, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("// changed\n")
+		f.Close()
+	})
+	if want := []trigger{{reason: "change", path: file}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restarts %+v, want %+v", got, want)
+	}
+}
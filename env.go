@@ -0,0 +1,161 @@
+package f5
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"net"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// DefaultEnvFile is the environment file loaded when it exists, unless
+// another one is given.
+const DefaultEnvFile = ".env"
+
+// envPath returns the absolute path of the environment file, or "" when
+// there is none. Only the default file may be missing.
+func envPath(name string) (string, error) {
+	if name == "" {
+		return "", nil
+	}
+	abs, err := filepath.Abs(name)
+	if err != nil {
+		return "", err
+	}
+	if _, err := os.Stat(abs); err != nil {
+		if os.IsNotExist(err) && name == DefaultEnvFile {
+			return "", nil
+		}
+		return "", err
+	}
+	return abs, nil
+}
+
+// environ returns the environment of the command: f5's own, or only the
+// variables of it kept with -clean-env, with the variables of the
+// environment file it does not set added. It is read on every start, so
+// edits to the file apply on the next restart.
+func (r *Run) environ() []string {
+	if r.envFile == "" && !r.cfg.CleanEnv {
+		return nil
+	}
+	env := r.baseEnv()
+	if r.envFile == "" {
+		return env
+	}
+	f, err := os.Open(r.envFile)
+	if err != nil {
+		r.printf(colorError, "Cannot read env file: %v", err)
+		return env
+	}
+	defer f.Close()
+	vars, err := parseEnv(f)
+	if err != nil {
+		r.printf(colorError, "Cannot read env file %s: %v", r.envFile, err)
+		return env
+	}
+	set := map[string]bool{}
+	for _, kv := range env {
+		k, _, _ := strings.Cut(kv, "=")
+		set[k] = true
+	}
+	for _, kv := range vars {
+		k, _, _ := strings.Cut(kv, "=")
+		if !set[k] {
+			env = append(env, kv)
+		}
+	}
+	return env
+}
+
+// baseEnv returns f5's environment, or with -clean-env only the variables
+// of it listed in -env-keep.
+func (r *Run) baseEnv() []string {
+	if !r.cfg.CleanEnv {
+		return os.Environ()
+	}
+	env := []string{}
+	for _, k := range r.cfg.EnvKeep {
+		if v, ok := os.LookupEnv(k); ok {
+			env = append(env, k+"="+v)
+		}
+	}
+	return env
+}
+
+// freePort returns a TCP port free to listen on, as picked by the system
+// for a listener closed right away.
+func freePort() (int, error) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		return 0, err
+	}
+	defer l.Close()
+	return l.Addr().(*net.TCPAddr).Port, nil
+}
+
+// parseEnv parses KEY=VALUE lines, as in a .env file. Blank lines and
+// lines starting with "#" are skipped, and an "export " prefix is allowed.
+// A value may be single quoted, taken literally, or double quoted, with
+// \n, \t, \" and \\ escapes; an unquoted value ends at " #".
+func parseEnv(rd io.Reader) ([]string, error) {
+	vars := []string{}
+	s := bufio.NewScanner(rd)
+	for n := 1; s.Scan(); n++ {
+		line := strings.TrimSpace(s.Text())
+		if line == "" || strings.HasPrefix(line, "#") {
+			continue
+		}
+		line = strings.TrimPrefix(line, "export ")
+		k, v, ok := strings.Cut(line, "=")
+		k = strings.TrimSpace(k)
+		if !ok || k == "" || strings.ContainsAny(k, " \t") {
+			return nil, fmt.Errorf("line %d: want KEY=VALUE, got %q", n, line)
+		}
+		v, err := envValue(strings.TrimSpace(v))
+		if err != nil {
+			return nil, fmt.Errorf("line %d: %v", n, err)
+		}
+		vars = append(vars, k+"="+v)
+	}
+	return vars, s.Err()
+}
+
+// envValue unquotes the value of a variable.
+func envValue(v string) (string, error) {
+	switch {
+	case strings.HasPrefix(v, "'"):
+		end := strings.IndexByte(v[1:], '\'')
+		if end < 0 {
+			return "", fmt.Errorf("unterminated quote in %s", v)
+		}
+		return v[1 : end+1], nil
+	case strings.HasPrefix(v, `"`):
+		var b strings.Builder
+		for i := 1; i < len(v); i++ {
+			switch c := v[i]; {
+			case c == '"':
+				return b.String(), nil
+			case c == '\\' && i+1 < len(v):
+				i++
+				switch v[i] {
+				case 'n':
+					b.WriteByte('\n')
+				case 't':
+					b.WriteByte('\t')
+				default:
+					b.WriteByte(v[i])
+				}
+			default:
+				b.WriteByte(c)
+			}
+		}
+		return "", fmt.Errorf("unterminated quote in %s", v)
+	}
+	if i := strings.Index(v, " #"); i >= 0 {
+		v = strings.TrimSpace(v[:i])
+	}
+	return v, nil
+}
@@ -0,0 +1,164 @@
+package f5
+
+import (
+	"bytes"
+	"io"
+	"net"
+	"os"
+	"reflect"
+	"strings"
+	"testing"
+)
+
+func TestParseEnv(t *testing.T) {
+	env := `# a comment
+PLAIN=value
+export EXPORTED=1
+
+SPACED = padded value  
+COMMENTED=value # a comment
+HASH=a#b
+SINGLE='literal \n # value'
+DOUBLE="line\nnext\t\"quoted\" \\ # kept"
+EMPTY=
+EQUALS=a=b
+`
+	got, err := parseEnv(strings.NewReader(env))
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := []string{
+		"PLAIN=value",
+		"EXPORTED=1",
+		"SPACED=padded value",
+		"COMMENTED=value",
+		"HASH=a#b",
+		`SINGLE=literal \n # value`,
+		"DOUBLE=line\nnext\t\"quoted\" \\ # kept",
+		"EMPTY=",
+		"EQUALS=a=b",
+	}
+	if !reflect.DeepEqual(got, want) {
+		t.Errorf("parsed %q, want %q", got, want)
+	}
+}
+
+func TestParseEnvErrors(t *testing.T) {
+	for _, env := range []string{
+		"NOVALUE",
+		"=value",
+		"TWO WORDS=value",
+		"OPEN='value",
+		`OPEN="value`,
+	} {
+		if _, err := parseEnv(strings.NewReader(env)); err == nil {
+			t.Errorf("parsed %q, want an error", env)
+		}
+	}
+}
+
+func TestEnviron(t *testing.T) {
+	dir := t.TempDir()
+	file := writeFile(t, dir, ".env", "F5_TEST_FILE=file\nF5_TEST_REAL=file\n")
+	t.Setenv("F5_TEST_REAL", "real")
+	t.Setenv("F5_TEST_KEEP", "kept")
+	t.Setenv("F5_TEST_DROP", "dropped")
+	tests := []struct {
+		name string
+		cfg  Config
+		want map[string]string
+	}{
+		{
+			name: "env file",
+			cfg:  Config{EnvFile: file},
+			want: map[string]string{
+				"F5_TEST_FILE": "file",
+				// the real environment wins.
+				"F5_TEST_REAL": "real",
+				"F5_TEST_KEEP": "kept",
+				"F5_TEST_DROP": "dropped",
+			},
+		},
+		{
+			name: "clean env",
+			cfg:  Config{CleanEnv: true, EnvKeep: []string{"F5_TEST_KEEP", "F5_TEST_UNSET"}},
+			want: map[string]string{
+				"F5_TEST_KEEP": "kept",
+			},
+		},
+		{
+			name: "clean env with an env file",
+			cfg:  Config{EnvFile: file, CleanEnv: true, EnvKeep: []string{"F5_TEST_REAL"}},
+			want: map[string]string{
+				"F5_TEST_FILE": "file",
+				"F5_TEST_REAL": "real",
+			},
+		},
+	}
+	for _, tt := range tests {
+		r := newTestRun(t, tt.cfg)
+		got := map[string]string{}
+		for _, kv := range r.environ() {
+			if k, v, _ := strings.Cut(kv, "="); strings.HasPrefix(k, "F5_TEST_") || tt.cfg.CleanEnv {
+				got[k] = v
+			}
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%s: environment %v, want %v", tt.name, got, tt.want)
+		}
+	}
+}
+
+func TestPortEnv(t *testing.T) {
+	r := newTestRun(t, Config{PortEnv: "F5_TEST_PORT"})
+	var port string
+	for _, kv := range r.command(trigger{reason: "start"}, io.Discard, io.Discard).Env {
+		if k, v, _ := strings.Cut(kv, "="); k == "F5_TEST_PORT" {
+			port = v
+		}
+	}
+	if port == "" {
+		t.Fatal("F5_TEST_PORT not set")
+	}
+	l, err := net.Listen("tcp", "localhost:"+port)
+	if err != nil {
+		t.Fatalf("port %s not usable: %v", port, err)
+	}
+	l.Close()
+}
+
+// TestCleanEnvChild checks the environment the command sees with
+// -clean-env, as run by env.
+func TestCleanEnvChild(t *testing.T) {
+	t.Setenv("F5_TEST_DROP", "dropped")
+	r, err := NewWithConfig(Config{CleanEnv: true, EnvKeep: []string{"PATH"}}, "env")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.watcher.Close() })
+	var out bytes.Buffer
+	cmd := r.command(trigger{reason: "start"}, &out, &out)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "PATH=" + os.Getenv("PATH") + "\n"; out.String() != want {
+		t.Errorf("environment %q, want only %q", out.String(), want)
+	}
+}
+
+func TestRunEnv(t *testing.T) {
+	r, err := NewWithConfig(Config{RunEnv: true}, "sh", "-c", `echo "$F5_RUN $F5_REASON $F5_TRIGGER"`)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.watcher.Close() })
+	var out bytes.Buffer
+	r.runs = 6
+	cmd := r.command(trigger{reason: "change", path: "/src/main.go"}, &out, &out)
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if want := "7 change /src/main.go\n"; out.String() != want {
+		t.Errorf("command saw %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,73 @@
+package f5
+
+import (
+	"context"
+	"encoding/json"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// eventHookRestart is how long f5 waits before starting the -event-hook
+// command again after it exited.
+const eventHookRestart = time.Second
+
+// eventHook keeps the -event-hook command running until ctx is done, and
+// writes it the events as JSON lines on its standard input.
+func (r *Run) eventHook(ctx context.Context, events <-chan Event, stop func()) {
+	defer stop()
+	for {
+		err := r.runEventHook(ctx, events)
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.printf(colorWarn, "Event hook failed: %v, starting it again in %s", err, eventHookRestart)
+		} else {
+			r.printf(colorWarn, "Event hook exited, starting it again in %s", eventHookRestart)
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(eventHookRestart):
+		}
+	}
+}
+
+// runEventHook runs the -event-hook command once, until it exits or ctx is
+// done, and returns the error it exited with.
+func (r *Run) runEventHook(ctx context.Context, events <-chan Event) error {
+	cmd := exec.Command("sh", "-c", r.cfg.EventHook)
+	cmd.Stdout = r.stdout
+	cmd.Stderr = r.stderr
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	in, err := cmd.StdinPipe()
+	if err != nil {
+		return err
+	}
+	if err := cmd.Start(); err != nil {
+		return err
+	}
+	exited := make(chan error, 1)
+	go func() { exited <- cmd.Wait() }()
+	enc := json.NewEncoder(in)
+	for {
+		select {
+		case err := <-exited:
+			return err
+		case e := <-events:
+			// a write failing means it exited, which is reported above.
+			enc.Encode(e)
+		case <-ctx.Done():
+			// end of input first, for it to finish on its own.
+			in.Close()
+			select {
+			case err := <-exited:
+				return err
+			case <-time.After(eventHookRestart):
+			}
+			syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+			return <-exited
+		}
+	}
+}
@@ -0,0 +1,69 @@
+package f5
+
+import (
+	"bufio"
+	"context"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// hookEvents returns the events the hook recorded in the file, once there
+// are n of them.
+func hookEvents(t *testing.T, path string, n int) []Event {
+	t.Helper()
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		f, err := os.Open(path)
+		if err == nil {
+			events := []Event{}
+			s := bufio.NewScanner(f)
+			for s.Scan() {
+				var e Event
+				if err := json.Unmarshal(s.Bytes(), &e); err != nil {
+					t.Fatalf("hook got %q: %v", s.Text(), err)
+				}
+				events = append(events, e)
+			}
+			f.Close()
+			if len(events) >= n {
+				return events
+			}
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("hook got fewer than %d events", n)
+		}
+	}
+}
+
+func TestEventHook(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "events")
+	// the hook exits after each event, to be started again.
+	r := newTestRun(t, Config{EventHook: "head -n 1 >> " + path})
+	events, stop := r.events.subscribe()
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.eventHook(ctx, events, stop)
+		close(done)
+	}()
+	defer func() {
+		cancel()
+		<-done
+	}()
+
+	code := 2
+	r.emit(Event{Type: "exit", PID: 42, Run: 1, Code: &code})
+	got := hookEvents(t, path, 1)
+	if e := got[0]; e.Type != "exit" || e.PID != 42 || e.Run != 1 || e.Code == nil || *e.Code != 2 || e.Time.IsZero() {
+		t.Errorf("hook got %+v, want the exit of run 1", e)
+	}
+	// once the first hook exited, for the next one to get it.
+	time.Sleep(eventHookRestart / 2)
+	r.emit(Event{Type: "change", Path: "main.go"})
+	got = hookEvents(t, path, 2)
+	if e := got[1]; e.Type != "change" || e.Path != "main.go" {
+		t.Errorf("hook started again got %+v, want the change", e)
+	}
+}
@@ -0,0 +1,64 @@
+package f5
+
+import (
+	"sync"
+	"time"
+)
+
+// Event is something that happened to the runner, as streamed to control
+// socket clients.
+type Event struct {
+	Time time.Time `json:"time"`
+	// Type is one of "start", "ready", "exit", "change", "error" or
+	// "reply".
+	Type string `json:"type"`
+	PID  int    `json:"pid,omitempty"`
+	// Run is the number of the run the event is about, counting from 1.
+	Run  int    `json:"run,omitempty"`
+	Path string `json:"path,omitempty"`
+	// Code is the exit code of an "exit" event.
+	Code    *int   `json:"code,omitempty"`
+	Message string `json:"message,omitempty"`
+}
+
+// eventBuffer is the number of events a slow subscriber can fall behind
+// before events are dropped for it.
+const eventBuffer = 64
+
+// bus fans out events to subscribers.
+type bus struct {
+	mu   sync.Mutex
+	subs map[chan Event]bool
+}
+
+// subscribe returns a channel receiving events, and a function to stop
+// receiving them.
+func (b *bus) subscribe() (<-chan Event, func()) {
+	c := make(chan Event, eventBuffer)
+	b.mu.Lock()
+	if b.subs == nil {
+		b.subs = map[chan Event]bool{}
+	}
+	b.subs[c] = true
+	b.mu.Unlock()
+	return c, func() {
+		b.mu.Lock()
+		delete(b.subs, c)
+		b.mu.Unlock()
+	}
+}
+
+// emit sends the event to all subscribers without blocking.
+func (r *Run) emit(e Event) {
+	if e.Time.IsZero() {
+		e.Time = time.Now()
+	}
+	r.events.mu.Lock()
+	defer r.events.mu.Unlock()
+	for c := range r.events.subs {
+		select {
+		case c <- e:
+		default:
+		}
+	}
+}
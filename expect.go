@@ -0,0 +1,100 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"sync"
+	"time"
+)
+
+// expectation looks for a string in the output of the command, for
+// -expect.
+type expectation struct {
+	want  []byte
+	found chan struct{}
+	once  sync.Once
+}
+
+func newExpectation(want string) *expectation {
+	return &expectation{want: []byte(want), found: make(chan struct{})}
+}
+
+// writer returns a writer passing output on to w while looking for the
+// expected string in it.
+func (e *expectation) writer(w io.Writer) io.Writer {
+	return &expectWriter{e: e, w: w}
+}
+
+type expectWriter struct {
+	e *expectation
+	w io.Writer
+	// tail is the end of the output so far, to find the string when it is
+	// split across writes.
+	tail []byte
+}
+
+func (x *expectWriter) Write(p []byte) (int, error) {
+	buf := append(x.tail, p...)
+	if bytes.Contains(buf, x.e.want) {
+		x.e.once.Do(func() { close(x.e.found) })
+	}
+	if keep := len(x.e.want) - 1; len(buf) > keep {
+		buf = buf[len(buf)-keep:]
+	}
+	x.tail = append(x.tail[:0], buf...)
+	return x.w.Write(p)
+}
+
+// expect waits for the expected output, and quits once it is found, the
+// command exits without printing it, or the timeout passes.
+func (r *Run) expect(ctx context.Context, events <-chan Event, stop func()) {
+	defer stop()
+	var timeout <-chan time.Time
+	if r.cfg.ExpectTimeout > 0 {
+		t := time.NewTimer(r.cfg.ExpectTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-r.expected.found:
+			r.printf(colorSuccess, "Found %q in the output", r.cfg.Expect)
+			r.Quit()
+			return
+		case <-timeout:
+			r.printf(colorError, "%q not found in the output within %s", r.cfg.Expect, r.cfg.ExpectTimeout)
+		case e := <-events:
+			if e.Type != "exit" && e.Type != "error" {
+				continue
+			}
+			// the output is all written once the exit is reported.
+			select {
+			case <-r.expected.found:
+				continue
+			default:
+			}
+			r.printf(colorError, "%q not found in the output before the command exited", r.cfg.Expect)
+		}
+		r.mu.Lock()
+		r.exitCode = ExitNotFound
+		r.mu.Unlock()
+		r.Quit()
+		return
+	}
+}
+
+// ExitNotFound is the status f5 exits with when the output expected with
+// -expect is not found.
+const ExitNotFound = 3
+
+// ExitCode returns the status f5 should exit with: ExitNotFound when the
+// output expected with -expect was not found, 130 when Ctrl-C was read as
+// a key, as for SIGINT, and 0 otherwise.
+func (r *Run) ExitCode() int {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.exitCode
+}
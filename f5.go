@@ -2,216 +2,1212 @@ package f5
 
 import (
 	"context"
+	"errors"
 	"fmt"
-	"io/fs"
-	"io/ioutil"
+	"io"
 	"log"
+	"net"
 	"os"
 	"os/exec"
 	"path/filepath"
+	"regexp"
 	"strings"
+	"sync"
+	"sync/atomic"
 	"syscall"
+	"time"
 
 	"github.com/fsnotify/fsnotify"
 	"github.com/pkg/term"
-	"github.com/tj/go-terminput"
+	"github.com/pkg/term/termios"
+	"golang.org/x/sys/unix"
 )
 
 var (
 	// extension of top langauges
-	supportedExtensionMap = map[string]bool{}
-	supportedExtensions   = []string{
+	supportedExtensions = []string{
 		".py", ".js", ".java", ".ts", ".go",
 		".cpp", ".rb", ".php", ".cs", ".c",
 	}
+	// dependency manifests of the same languages, watched with
+	// -watch-manifests.
+	manifests = map[string]bool{
+		"go.mod": true, "go.sum": true,
+		"package.json": true, "package-lock.json": true,
+		"Cargo.toml": true, "Cargo.lock": true,
+		"requirements.txt": true, "Pipfile.lock": true,
+		"Gemfile": true, "Gemfile.lock": true,
+		"pom.xml": true, "composer.json": true,
+	}
 )
 
-const (
-	colorReset  = "\033[0m"
-	colorRed    = "\033[31m"
-	colorGreen  = "\033[32m"
-	colorYellow = "\033[33m"
-	colorBlue   = "\033[34m"
-	colorPurple = "\033[35m"
-	colorCyan   = "\033[36m"
-	colorWhite  = "\033[37m"
-	separator   = "------------------------------------------------------------------"
-)
+const separator = "------------------------------------------------------------------"
 
-func init() {
-	for _, s := range supportedExtensions {
-		supportedExtensionMap[s] = true
-	}
+// DefaultExtensions returns the file extensions watched by default.
+func DefaultExtensions() []string {
+	return append([]string(nil), supportedExtensions...)
 }
 
-func (r *Run) printf(color string, format string, a ...any) {
-	f := color + format + colorReset
+func (r *Run) printf(c color, format string, a ...any) {
+	f := r.theme[c] + format + r.theme.reset()
 	r.logger.Printf(f, a...)
 }
 
-func (r *Run) usagef(color string, format string, a ...any) {
-	f := color + format + colorReset
+func (r *Run) debugf(format string, a ...any) {
+	if atomic.LoadInt32(&r.debug) == 0 {
+		return
+	}
+	r.printf(colorDebug, "debug: "+format, a...)
+}
+
+func (r *Run) usagef(c color, format string, a ...any) {
+	f := r.theme[c] + format + r.theme.reset()
 	r.usage.Printf(f, a...)
 }
 
 type Run struct {
-	args    []string
-	process *os.Process
-	watcher *fsnotify.Watcher
-	term    *term.Term
+	cfg        Config
+	theme      theme
+	extColors  map[string]string
+	args       []string
+	roots      []*root
+	only       []string
+	envFile    string
+	configFile string
+	keySignals map[string]syscall.Signal
+	// reloadSignal is sent for changes to files with -reload-ext
+	// extensions.
+	reloadSignal syscall.Signal
+	// ops are the operations on watched files restarting the command.
+	ops  fsnotify.Op
+	cred *syscall.Credential
+	// limits, if set, is the value of limitEnv, and self the executable
+	// run as the helper setting them, and with -go-status, as the helper
+	// reporting how the program built by go run exited, as run by goArgs.
+	limits     string
+	self       string
+	goArgs     []string
+	watcher    *fsnotify.Watcher
+	term       *term.Term
+	tui        *tui
+	expected   *expectation
+	replay     *replay
+	tee        *tee
+	handoff    handoff
+	pane       *pane
+	gits       []*gitRepo
+	imports    *imports
+	resume     *resume
+	readyRegex *regexp.Regexp
+	sock       net.Listener
+	events     bus
+	settler    settler
+	poller     poller
+	goSums     goSums
+
+	warnWatchLimit sync.Once
+	// watching serializes changes to the set of watched directories.
+	watching sync.Mutex
+	// pidFile serializes writing and removing the -pid-file.
+	pidFile sync.Mutex
+
+	// warmup is when file changes start triggering restarts, set by Start.
+	warmup time.Time
+	// idle stops the command after -idle-stop without triggers.
+	idle *time.Timer
+
+	// debug is set while debug logging is on, initially cfg.Debug.
+	debug int32
+	// asking is held while a -confirm prompt waits for a key from answers.
+	asking  sync.Mutex
+	answers chan string
+
+	// out receives f5's own output, stdout and stderr the command's.
+	out    io.Writer
+	stdout io.Writer
+	stderr io.Writer
 
-	restart chan bool
-	logger  *log.Logger
-	usage   *log.Logger
+	// launch serializes restarts; mu guards the fields below it.
+	launch sync.Mutex
+	mu     sync.Mutex
+	proc   *proc
+	// live holds the processes started and not reaped yet, including
+	// those being stopped.
+	live map[*proc]bool
+	// watched holds the directories watched or polled.
+	watched map[string]bool
+	runs    int
+	crashes int
+	// began is when Start was called, and changes counts the changes of
+	// each file, for -summary.
+	began   time.Time
+	changes map[string]int
+	paused  bool
+	closing bool
+	// active is when the last trigger was accepted, for -idle-stop.
+	active time.Time
+	// dropped counts the triggers dropped since the last restart, as
+	// more were pending than the restart channel holds.
+	dropped int
+	// reloading is set when the config file changed.
+	reloading bool
+	exitCode  int
+
+	restart   chan trigger
+	quit      chan struct{}
+	quitOnce  sync.Once
+	closed    chan struct{}
+	closeOnce sync.Once
+	cancel    context.CancelFunc
+	wg        sync.WaitGroup
+	logger    *log.Logger
+	usage     *log.Logger
 }
 
 func New(args ...string) (*Run, error) {
-	watcher, err := fsnotify.NewWatcher()
+	return NewWithConfig(Config{}, args...)
+}
+
+func NewWithConfig(cfg Config, args ...string) (*Run, error) {
+	cfg = cfg.Resolved()
+	roots := []*root{}
+	for _, c := range cfg.Roots {
+		rt, err := newRoot(c, cfg)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, rt)
+	}
+	for _, path := range cfg.AlsoWatch {
+		rt, err := newExtraRoot(path, cfg)
+		if err != nil {
+			return nil, err
+		}
+		roots = append(roots, rt)
+	}
+	only := []string{}
+	for _, d := range cfg.OnlyDirs {
+		abs, err := filepath.Abs(d)
+		if err != nil {
+			return nil, err
+		}
+		only = append(only, abs)
+	}
+	if err := checkColor(cfg.Color); err != nil {
+		return nil, err
+	}
+	th, err := lookupTheme(cfg.Theme)
+	if err != nil {
+		return nil, err
+	}
+	colors, err := extColors(cfg.ExtColors)
+	if err != nil {
+		return nil, err
+	}
+	sigs, err := keySignals(cfg.KeySignals)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.WarmupURL != "" {
+		if _, err := newWarmup(context.Background(), cfg); err != nil {
+			return nil, fmt.Errorf("-warmup-url: %v", err)
+		}
+	}
+	ops, err := parseOps(cfg.Ops, cfg.RestartOnDelete)
 	if err != nil {
 		return nil, err
 	}
-	t, err := term.Open("/dev/tty")
+	var reloadSig syscall.Signal
+	if len(cfg.ReloadExt) > 0 {
+		if reloadSig, err = parseSignal(cfg.ReloadSignal); err != nil {
+			return nil, fmt.Errorf("-reload-signal: %v", err)
+		}
+	}
+	envFile, err := envPath(cfg.EnvFile)
 	if err != nil {
 		return nil, err
 	}
+	configFile := ""
+	if cfg.ConfigFile != "" {
+		if configFile, err = filepath.Abs(cfg.ConfigFile); err != nil {
+			return nil, err
+		}
+	}
+	if err := checkSpaceKey(cfg.SpaceKey); err != nil {
+		return nil, err
+	}
+	if err := checkConfirm(cfg.Confirm); err != nil {
+		return nil, err
+	}
+	if cfg.Stdin && (cfg.PTY || cfg.NoProcessGroup) {
+		return nil, fmt.Errorf("-stdin cannot be used with -pty or -no-pgid")
+	}
+	if cfg.Tmux && (cfg.PTY || cfg.Stdin) {
+		return nil, fmt.Errorf("-tmux cannot be used with -pty or -stdin")
+	}
+	if cfg.BlueGreen && (cfg.ReadyRegex == "" || cfg.PortEnv == "") {
+		return nil, fmt.Errorf("-blue-green needs -ready-regex and -port-env")
+	}
+	if cfg.BlueGreen && (cfg.Stdin || cfg.Tmux) {
+		return nil, fmt.Errorf("-blue-green cannot be used with -stdin or -tmux")
+	}
+	cred, err := credential(cfg.User, cfg.Group)
+	if err != nil {
+		return nil, err
+	}
+	limits, err := parseLimits(cfg.Limits)
+	if err != nil {
+		return nil, err
+	}
+	if cfg.GoStatus && !isGoRun(args) {
+		return nil, fmt.Errorf("-go-status needs a go run command without -exec")
+	}
+	self := ""
+	if limits != "" || cfg.GoStatus {
+		if self, err = os.Executable(); err != nil {
+			return nil, err
+		}
+	}
+	var goArgs []string
+	if cfg.GoStatus {
+		if goArgs, err = goRunArgs(args, self); err != nil {
+			return nil, err
+		}
+	}
+	watcher, err := fsnotify.NewWatcher()
+	if err != nil {
+		return nil, err
+	}
+	// read keys only when run interactively, so f5 does not take over the
+	// terminal of a script piping its input.
+	var t *term.Term
+	if isatty(os.Stdin) {
+		if t, err = term.Open("/dev/tty"); err != nil {
+			return nil, err
+		}
+	}
 
-	fn := filepath.Base(args[0])
-	prefix := fmt.Sprintf("%s[Press F5 to refresh %q] %s", colorGreen, fn, colorReset)
-	logger := log.New(os.Stderr, prefix, log.LstdFlags)
-	usage := log.New(os.Stderr, prefix, 0)
 	r := Run{
-		args:    args,
-		restart: make(chan bool, 100),
-		watcher: watcher,
-		term:    t,
-		logger:  logger,
-		usage:   usage,
+		cfg:          cfg,
+		theme:        th,
+		extColors:    colors,
+		args:         args,
+		roots:        roots,
+		only:         only,
+		envFile:      envFile,
+		configFile:   configFile,
+		keySignals:   sigs,
+		reloadSignal: reloadSig,
+		ops:          ops,
+		cred:         cred,
+		limits:       limits,
+		self:         self,
+		goArgs:       goArgs,
+		restart:      make(chan trigger, 100),
+		quit:         make(chan struct{}),
+		closed:       make(chan struct{}),
+		live:         map[*proc]bool{},
+		changes:      map[string]int{},
+		watched:      map[string]bool{},
+		answers:      make(chan string),
+		watcher:      watcher,
+		term:         t,
+		out:          os.Stdout,
+		stdout:       os.Stdout,
+		stderr:       os.Stderr,
 	}
+	if cfg.Debug {
+		r.debug = 1
+	}
+	if cfg.WatchGit {
+		if r.gits, err = findGits(roots); err != nil {
+			return nil, err
+		}
+	}
+	if cfg.Resume {
+		if r.resume, err = openResume(); err != nil {
+			return nil, fmt.Errorf("cannot read -resume state: %v", err)
+		}
+	}
+	if cfg.ImportAware {
+		target := goTarget(args)
+		if target == nil {
+			return nil, fmt.Errorf("-import-aware needs a go run or go build command")
+		}
+		r.imports = &imports{target: target}
+	}
+	logs := io.Writer(os.Stderr)
+	if cfg.TUI {
+		r.tui = newTUI(&r)
+		r.out, r.stdout, r.stderr, logs = r.tui, r.tui, r.tui, r.tui
+	}
+	if cfg.MaxOutputRate > 0 {
+		t := newThrottle(cfg.MaxOutputRate)
+		r.stdout, r.stderr = t.writer(r.stdout), t.writer(r.stderr)
+	}
+	if cfg.Tee != "" {
+		if r.tee, err = openTee(cfg.Tee, cfg.TeeAppend); err != nil {
+			return nil, err
+		}
+		r.stdout, r.stderr = io.MultiWriter(r.stdout, r.tee), io.MultiWriter(r.stderr, r.tee)
+	}
+	if cfg.TimestampOutput {
+		layout := cfg.TimestampFormat
+		if layout == "" {
+			layout = DefaultTimestampFormat
+		}
+		r.stdout, r.stderr = newStamper(r.stdout, layout), newStamper(r.stderr, layout)
+	}
+	if cfg.LineBuffered {
+		r.stdout = newLineWriter(r.stdout, cfg.MaxOutputBytes)
+		r.stderr = newLineWriter(r.stderr, cfg.MaxOutputBytes)
+	}
+	if cfg.Replay > 0 {
+		r.replay = newReplay(cfg.Replay, cfg.MaxOutputBytes)
+		r.stdout, r.stderr = r.replay.writer(r.stdout), r.replay.writer(r.stderr)
+	}
+	if cfg.ReadyRegex != "" {
+		re, err := regexp.Compile(cfg.ReadyRegex)
+		if err != nil {
+			return nil, fmt.Errorf("bad -ready-regex: %v", err)
+		}
+		r.readyRegex = re
+	}
+	if cfg.Expect != "" {
+		r.expected = newExpectation(cfg.Expect)
+		r.stdout, r.stderr = r.expected.writer(r.stdout), r.expected.writer(r.stderr)
+	}
+	prefix := fmt.Sprintf("%s[Press F5 to refresh %q] %s", r.theme[colorSuccess], filepath.Base(args[0]), r.theme.reset())
+	if cfg.Label != "" {
+		prefix = fmt.Sprintf("%s[%s] %s", r.theme[colorSuccess], cfg.Label, r.theme.reset())
+	}
+	r.logger = log.New(logs, prefix, log.LstdFlags)
+	r.usage = log.New(logs, prefix, 0)
 	return &r, nil
 }
 
 func (r *Run) kill() {
-	if r.process != nil {
-		pid := r.process.Pid
-		err := syscall.Kill(-pid, syscall.SIGINT)
-		if err != nil && !strings.Contains(err.Error(), "no such process") {
-			r.printf(colorRed, "Process %d: cannot interrupt: %v", pid, err)
-			r.printf(colorPurple, "Process %d: sending sigkill", pid)
-			err := syscall.Kill(-pid, syscall.SIGKILL)
-			if err != nil {
-				r.printf(colorRed, "Process %d: cannot be killed: %v", pid, err)
-			}
+	r.mu.Lock()
+	p := r.proc
+	r.proc = nil
+	r.mu.Unlock()
+	if p != nil {
+		r.stop(p)
+	}
+}
+
+// stop interrupts the process p, and kills it if it does not exit.
+func (r *Run) stop(p *proc) {
+	pid := p.Pid
+	err := syscall.Kill(r.target(pid), syscall.SIGINT)
+	if err == nil && !p.exited() {
+		// the process may ignore the signal.
+		p.stopped = time.Now()
+		r.goroutine(func() { r.force(p) })
+	}
+	if err != nil && !strings.Contains(err.Error(), "no such process") {
+		r.printf(colorError, "Process %d: cannot interrupt: %v", pid, err)
+		r.printf(colorWarn, "Process %d: sending sigkill", pid)
+		err := syscall.Kill(r.target(pid), syscall.SIGKILL)
+		if err != nil {
+			r.printf(colorError, "Process %d: cannot be killed: %v", pid, err)
 		}
-		r.process = nil
 	}
 }
 
+// target returns the pid to signal for the process: its whole process
+// group, unless the command runs without one.
+func (r *Run) target(pid int) int {
+	if r.cfg.NoProcessGroup {
+		return pid
+	}
+	return -pid
+}
+
+// Quit shuts down the runner, as the q key does. It is safe to call more
+// than once.
+func (r *Run) Quit() {
+	r.quitOnce.Do(func() { close(r.quit) })
+}
+
+// reload shuts down the runner for its config file changed, for the
+// caller to start again with the new settings.
+func (r *Run) reload() {
+	r.mu.Lock()
+	again := r.reloading
+	r.reloading = true
+	r.mu.Unlock()
+	if again {
+		// an editor may write the file more than once.
+		return
+	}
+	r.printf(colorSuccess, "Config file changed: %s, reloading", r.configFile)
+	r.Quit()
+}
+
+// Reloading reports whether the runner shut down because its config file
+// changed, and is to be started again with the new settings.
+func (r *Run) Reloading() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.reloading
+}
+
+// Done is closed when Quit is called.
+func (r *Run) Done() <-chan struct{} {
+	return r.quit
+}
+
+// Wait blocks until the runner has shut down, that is, the context given
+// to Start is done, Quit was called or the q key pressed, or Close was
+// called, and the runner's goroutines have returned. A typical embedder
+// calls New, Start, ListenForKeys in a goroutine, then Wait.
+func (r *Run) Wait() {
+	<-r.closed
+}
+
+// goroutine runs f in a goroutine that Close waits for.
+func (r *Run) goroutine(f func()) {
+	r.wg.Add(1)
+	go func() {
+		defer r.wg.Done()
+		f()
+	}()
+}
+
+// status returns the current process, if any, and the number of times the
+// command has been started.
+func (r *Run) status() (*proc, int) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.proc, r.runs
+}
+
+func (r *Run) isPaused() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.paused
+}
+
+// toggleDebug switches debug logging on or off.
+func (r *Run) toggleDebug() {
+	if atomic.CompareAndSwapInt32(&r.debug, 0, 1) {
+		r.printf(colorWarn, "debug logging: on")
+		return
+	}
+	atomic.StoreInt32(&r.debug, 0)
+	r.printf(colorWarn, "debug logging: off")
+}
+
+// togglePause stops or resumes restarting on file changes.
+func (r *Run) togglePause() {
+	r.mu.Lock()
+	r.paused = !r.paused
+	paused := r.paused
+	r.mu.Unlock()
+	if paused {
+		r.printf(colorWarn, "Paused, file changes are ignored until p is pressed again.")
+	} else {
+		r.printf(colorWarn, "Resumed watching file changes.")
+	}
+}
+
+// Close stops the command and releases the resources of the runner. It is
+// safe to call more than once.
 func (r *Run) Close() {
-	r.term.Restore()
+	r.closeOnce.Do(r.close)
+}
+
+func (r *Run) close() {
+	// stop accepting restarts, and wait for one in progress to finish so
+	// no process is started after the kill below.
+	r.mu.Lock()
+	r.closing = true
+	r.mu.Unlock()
+	if r.cancel != nil {
+		r.cancel()
+	}
+	r.launch.Lock()
+	if r.idle != nil {
+		r.idle.Stop()
+	}
+	r.drain()
+	if r.term != nil {
+		if r.cfg.Stdin {
+			foreground()
+		}
+		r.term.Restore()
+	}
 	r.watcher.Close()
+	p, _ := r.status()
 	r.kill()
+	r.reap()
+	if r.pane != nil {
+		r.pane.close()
+	}
+	r.cleanup(p)
+	r.removePIDFile(nil)
+	r.closeSocket()
+	r.launch.Unlock()
+	r.wg.Wait()
+	if r.tee != nil {
+		r.tee.close()
+	}
+	if r.cfg.Summary {
+		r.summary()
+	}
+	if r.tui != nil {
+		r.tui.stop()
+	}
+	close(r.closed)
 }
 
-func (r *Run) Restart(ctx context.Context) {
-	r.kill()
-	cmd := exec.Command(r.args[0], r.args[1:]...)
+// send queues a restart, unless the runner is shutting down or enough
+// restarts are pending already.
+func (r *Run) send(t trigger) {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	if r.closing {
+		return
+	}
+	select {
+	case r.restart <- t:
+	default:
+		r.dropped++
+	}
+}
+
+// drain drops the pending restarts.
+func (r *Run) drain() {
+	for {
+		select {
+		case <-r.restart:
+		default:
+			return
+		}
+	}
+}
+
+func (r *Run) isClosing() bool {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	return r.closing
+}
+
+const (
+	// launchRetries is how many times starting the command is retried on
+	// a transient error, such as a freshly built binary still being busy.
+	launchRetries = 3
+	// launchBackoff is the delay before the first retry, doubled for each
+	// following one.
+	launchBackoff = 50 * time.Millisecond
+)
+
+// command returns the command to start for t, writing its output to
+// stdout and stderr.
+func (r *Run) command(t trigger, stdout, stderr io.Writer) *exec.Cmd {
+	args := r.args
+	if r.goArgs != nil {
+		args = r.goArgs
+	}
+	args = append(strings.Fields(r.cfg.Wrap), args...)
+	if r.limits != "" {
+		args = append([]string{r.self}, args...)
+	}
+	cmd := exec.Command(args[0], args[1:]...)
 	// set process group, so we can kill all of the spawned processes.
-	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
-	cmd.Stdout = os.Stdout
-	cmd.Stderr = os.Stderr
-	err := cmd.Start()
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: !r.cfg.NoProcessGroup, Credential: r.cred}
+	cmd.Stdout = stdout
+	cmd.Stderr = stderr
+	if r.cfg.MergeOutput {
+		// the same writer gets both in the order they were written.
+		cmd.Stderr = stdout
+	}
+	if r.cfg.Stdin {
+		r.prepareStdin(cmd)
+	}
+	if r.pane != nil {
+		cmd.Stdin, cmd.Stdout, cmd.Stderr = r.pane.tty, r.pane.tty, r.pane.tty
+	}
+	cmd.Env = r.environ()
+	extra := []string{}
+	if r.limits != "" {
+		extra = append(extra, limitEnv+"="+r.limits)
+	}
+	if r.goArgs != nil {
+		_, runs := r.status()
+		status := goStatusFile(runs + 1)
+		os.Remove(status)
+		extra = append(extra, goStatusEnv+"="+status)
+	}
+	if r.cfg.RunEnv {
+		_, runs := r.status()
+		extra = append(extra, fmt.Sprintf("F5_RUN=%d", runs+1), "F5_REASON="+t.reason, "F5_TRIGGER="+t.path)
+	}
+	if r.cfg.PortEnv != "" {
+		if port, err := freePort(); err != nil {
+			r.printf(colorError, "Cannot find a free port: %v", err)
+		} else {
+			r.printf(colorInfo, "Using port %d as $%s", port, r.cfg.PortEnv)
+			extra = append(extra, fmt.Sprintf("%s=%d", r.cfg.PortEnv, port))
+		}
+	}
+	if len(extra) > 0 {
+		if cmd.Env == nil {
+			cmd.Env = os.Environ()
+		}
+		// the last value of a variable wins.
+		cmd.Env = append(cmd.Env, extra...)
+	}
+	return cmd
+}
+
+// start starts the command, retrying with backoff on transient errors.
+func (r *Run) start(t trigger, stdout, stderr io.Writer) (*exec.Cmd, *os.File, error) {
+	delay := launchBackoff
+	for i := 0; ; i++ {
+		cmd := r.command(t, stdout, stderr)
+		var tty *os.File
+		var err error
+		if r.cfg.PTY {
+			tty, err = r.startPTY(cmd)
+		} else {
+			err = cmd.Start()
+		}
+		if err == nil || i == launchRetries || !transient(err) {
+			return cmd, tty, err
+		}
+		r.printf(colorWarn, "Cannot run command: %v, retrying in %s", err, delay)
+		time.Sleep(delay)
+		delay *= 2
+	}
+}
+
+// transient reports whether starting a command may succeed when retried.
+func transient(err error) bool {
+	return errors.Is(err, syscall.ETXTBSY) || errors.Is(err, syscall.EAGAIN)
+}
+
+// groupWait is how long a restart waits with -group-output for the previous
+// run to end, so its end banner comes before the next begin banner.
+const groupWait = time.Second
+
+// banner returns a separator line with the title in it.
+func banner(title string) string {
+	b := "── " + title + " "
+	if n := len(separator) - len([]rune(b)); n > 0 {
+		b += strings.Repeat("─", n)
+	}
+	return b
+}
+
+// shellQuote returns args as a command line that can be pasted into a
+// POSIX shell.
+func shellQuote(args []string) string {
+	quoted := make([]string, len(args))
+	for i, a := range args {
+		safe := a != ""
+		for _, c := range a {
+			if !(c >= 'a' && c <= 'z' || c >= 'A' && c <= 'Z' || c >= '0' && c <= '9' || strings.ContainsRune("_@%+=:,./-", c)) {
+				safe = false
+				break
+			}
+		}
+		if safe {
+			quoted[i] = a
+		} else {
+			quoted[i] = "'" + strings.ReplaceAll(a, "'", `'\''`) + "'"
+		}
+	}
+	return strings.Join(quoted, " ")
+}
+
+// trigger is a request to restart the command.
+type trigger struct {
+	// reason is what asked for the restart, such as "start", "key" or
+	// "change".
+	reason string
+	// path is the changed file, for a "change" trigger.
+	path string
+	// build is set on a trigger coalesced from several when one of them
+	// needs the -build command.
+	build bool
+	// merged is how many more triggers were coalesced into this one.
+	merged int
+	// restart is set on a trigger coalesced from several when one of them
+	// needs a restart rather than the -reload-signal.
+	restart bool
+}
+
+// needsBuild reports whether the -build command runs before restarting
+// for t: always, unless -rebuild-ext limits it to changes to files with
+// those extensions.
+func (r *Run) needsBuild(t trigger) bool {
+	if t.build || (t.reason != "change" && t.reason != "delete") || len(r.cfg.RebuildExt) == 0 {
+		return true
+	}
+	ext := filepath.Ext(t.path)
+	for _, e := range r.cfg.RebuildExt {
+		if normalizeExt(e) == ext {
+			return true
+		}
+	}
+	return false
+}
+
+// needsRestart reports whether t restarts the command, rather than only
+// sending it the -reload-signal for a change to a file with one of the
+// -reload-ext extensions.
+func (r *Run) needsRestart(t trigger) bool {
+	if t.restart || t.reason != "change" || len(r.cfg.ReloadExt) == 0 {
+		return true
+	}
+	ext := filepath.Ext(t.path)
+	for _, e := range r.cfg.ReloadExt {
+		if normalizeExt(e) == ext {
+			return false
+		}
+	}
+	return true
+}
+
+// coalesce merges the pending restarts into t, so that restarting once
+// covers them all, with the build if any of them needs it, and as a
+// restart if any of them needs one.
+func (r *Run) coalesce(t trigger) trigger {
+	for {
+		select {
+		case next := <-r.restart:
+			next.build = r.needsBuild(t) || r.needsBuild(next)
+			next.restart = r.needsRestart(t) || r.needsRestart(next)
+			next.merged += t.merged + 1
+			t = next
+		default:
+			return t
+		}
+	}
+}
+
+// coalesced tells how many triggers the restart for t stood for, when
+// they came in faster than the command restarted, so that it is clear why
+// there are fewer restarts than changes.
+func (r *Run) coalesced(t trigger) {
+	r.mu.Lock()
+	dropped := r.dropped
+	r.dropped = 0
+	r.mu.Unlock()
+	if t.merged == 0 && dropped == 0 {
+		return
+	}
+	msg := fmt.Sprintf("Coalesced %d triggers into 1 restart", t.merged+dropped+1)
+	if t.reason == "change" || t.reason == "delete" {
+		msg = fmt.Sprintf("Coalesced %d changes into 1 restart", t.merged+dropped+1)
+	}
+	if dropped > 0 {
+		msg += fmt.Sprintf(", %d of them dropped while the restart queue was full", dropped)
+	}
+	r.printf(colorInfo, "%s", msg)
+}
+
+// Restart restarts the command, as pressing F5 does.
+func (r *Run) Restart(ctx context.Context) {
+	r.restartFor(ctx, trigger{reason: "key"})
+}
+
+func (r *Run) restartFor(ctx context.Context, t trigger) {
+	r.keepAlive()
+	if !r.needsRestart(t) {
+		// a stopped command starts again as for any change.
+		if p, _ := r.status(); p != nil && !p.exited() {
+			r.signal(r.reloadSignal)
+			return
+		}
+	}
+	if !r.confirmed(ctx, t) {
+		return
+	}
+	r.launch.Lock()
+	defer r.launch.Unlock()
+	if r.isClosing() {
+		return
+	}
+	prev, runs := r.status()
+	if r.expected != nil && runs > 0 {
+		r.debugf("not restarting, -expect runs the command once")
+		return
+	}
+	if r.cfg.Count > 0 && runs > r.cfg.Count {
+		r.printf(colorWarn, "reached restart limit (%d), exiting", r.cfg.Count)
+		r.Quit()
+		return
+	}
+	if r.cfg.Build != "" {
+		// build while the previous run goes on, and keep it running if
+		// the build fails.
+		if !r.needsBuild(t) {
+			r.debugf("skipping build for %s", t.path)
+		} else if err := r.hook(ctx, "build", r.cfg.Build); err != nil {
+			r.emit(Event{Type: "error", Message: "build failed: " + err.Error()})
+			return
+		}
+	}
+	alive := prev != nil && !prev.exited()
+	var ran time.Duration
+	if alive {
+		ran = time.Since(prev.started)
+	}
+	// with -blue-green, the previous run goes on until the new one is
+	// ready.
+	blueGreen := r.cfg.BlueGreen && alive
+	if !blueGreen {
+		r.kill()
+	}
+	if r.cfg.GroupOutput && prev != nil && !blueGreen {
+		// let the previous run print its end banner first.
+		select {
+		case <-prev.done:
+		case <-time.After(groupWait):
+		}
+	}
+	if r.cfg.Clear && runs > 0 && r.tui == nil {
+		// keep the startup messages on screen for the first run.
+		fmt.Fprint(r.out, "\033[H\033[2J\033[3J")
+	}
+	if r.cfg.GroupOutput {
+		// print the begin banner first, as the command may write output
+		// as soon as it starts.
+		fmt.Fprintf(r.out, "%s%s%s\n", r.theme[colorSuccess], banner(fmt.Sprintf("run #%d begin", runs+1)), r.theme.reset())
+	}
+	if r.tee != nil && runs > 0 {
+		if err := r.tee.rotate(); err != nil {
+			r.printf(colorError, "Cannot open tee file: %v", err)
+		}
+	}
+	if r.replay != nil && runs > 0 {
+		r.replay.rotate(runs)
+	}
+	stdout, stderr := r.stdout, r.stderr
+	var ready <-chan struct{}
+	if r.readyRegex != nil {
+		x := newReadiness(r.readyRegex, r.cfg.MaxOutputBytes)
+		stdout, stderr, ready = x.tap(stdout), x.tap(stderr), x.ready
+	}
+	cmd, tty, err := r.start(t, stdout, stderr)
 	if err != nil {
-		r.printf(colorRed, "Cannot run command: %v", err)
+		r.printf(colorError, "Cannot run command: %v", err)
+		r.emit(Event{Type: "error", Message: err.Error()})
+		if r.cfg.Stdin && r.term != nil {
+			r.resumeKeys()
+		}
 		return
 	}
-	r.process = cmd.Process
-	fmt.Printf("%s%s\n", colorGreen, separator)
-	r.printf(colorWhite, "Process %d started for command: %s%s", cmd.Process.Pid, colorCyan, cmd)
-	fmt.Printf("%s%s%s\n", colorGreen, separator, colorReset)
+	r.mu.Lock()
+	r.runs++
+	p := &proc{Process: cmd.Process, run: r.runs, started: time.Now(), done: make(chan struct{}), pty: tty}
+	r.proc = p
+	r.live[p] = true
+	r.mu.Unlock()
+	if tty != nil {
+		p.output = make(chan struct{})
+		go r.copyPTY(p, stdout)
+	}
+	r.emit(Event{Type: "start", PID: p.Pid, Run: p.run, Path: t.path, Message: t.reason})
+	if err := r.writePIDFile(cmd.Process.Pid); err != nil {
+		r.printf(colorError, "Cannot write pid file: %v", err)
+	}
+	if !r.cfg.GroupOutput {
+		fmt.Fprintf(r.out, "%s%s\n", r.theme[colorSuccess], separator)
+	}
+	r.printf(colorInfo, "Process %d started for command: %s%s", cmd.Process.Pid, r.theme[colorAccent], cmd)
+	if r.cfg.EchoCommand {
+		r.printf(colorInfo, "%s$ %s", r.theme[colorAccent], shellQuote(r.args))
+	}
+	if t.reason == "change" && alive {
+		r.printf(colorInfo, "Process %d ran for %s before restart", prev.Pid, ran.Round(time.Second))
+	}
+	r.coalesced(t)
+	fmt.Fprintf(r.out, "%s%s%s\n", r.theme[colorSuccess], separator, r.theme.reset())
 
-	go cmd.Wait()
+	r.goroutine(func() { r.wait(cmd, p) })
+	if r.imports != nil {
+		r.goroutine(func() { r.updateImports(ctx) })
+	}
+	if r.cfg.OnStart != "" {
+		r.onStart(ctx, p)
+	}
+	if ready != nil {
+		r.goroutine(func() { r.awaitReady(ctx, p, ready) })
+	} else if r.cfg.WarmupURL != "" {
+		go r.warmUp(ctx, p, true)
+	}
+	if blueGreen {
+		// without holding up other restarts until it is ready.
+		r.goroutine(func() { r.swap(ctx, prev, p, ready) })
+	}
+}
+
+// keepAlive restarts the -idle-stop timer for a trigger accepted, be it
+// a restart or only the -reload-signal.
+func (r *Run) keepAlive() {
+	if r.idle == nil {
+		return
+	}
+	r.mu.Lock()
+	r.active = time.Now()
+	r.mu.Unlock()
+	r.idle.Reset(r.cfg.IdleStop)
+}
 
+// stopIdle stops the command when no trigger was accepted for
+// -idle-stop. The next one starts it again.
+func (r *Run) stopIdle() {
+	r.launch.Lock()
+	defer r.launch.Unlock()
+	r.mu.Lock()
+	idle := time.Since(r.active)
+	r.mu.Unlock()
+	if r.isClosing() || idle < r.cfg.IdleStop {
+		return
+	}
+	if p, _ := r.status(); p == nil || p.exited() {
+		return
+	}
+	r.kill()
+	r.printf(colorWarn, "Stopped after %s idle, will start again on change", r.cfg.IdleStop)
 }
 
+// Start watches for changes and starts the command. The runner shuts down
+// when ctx is done or Quit is called, and Wait returns once it has.
 func (r *Run) Start(ctx context.Context) error {
-	fmt.Printf("%s%s\n", colorGreen, separator)
-	r.usagef(colorWhite, "To restart the running program, press F5 or SPACE or Ctrl-R, or just make file changes.")
-	go func() {
+	ctx, r.cancel = context.WithCancel(ctx)
+	r.mu.Lock()
+	r.began = time.Now()
+	r.mu.Unlock()
+	r.warmup = r.began.Add(r.cfg.Warmup)
+	if r.tui != nil {
+		r.tui.start(ctx)
+	}
+	fmt.Fprintf(r.out, "%s%s\n", r.theme[colorSuccess], separator)
+	if r.Interactive() {
+		switch r.cfg.SpaceKey {
+		case "none", "pause":
+			r.usagef(colorInfo, "To restart the running program, press F5 or Ctrl-R, or just make file changes.")
+		default:
+			r.usagef(colorInfo, "To restart the running program, press F5 or SPACE or Ctrl-R, or just make file changes.")
+		}
+		if r.cfg.SpaceKey == "pause" {
+			r.usagef(colorInfo, "Press p or SPACE to pause and resume watching, d to toggle debug logging, q to quit.")
+		} else {
+			r.usagef(colorInfo, "Press p to pause and resume watching, d to toggle debug logging, q to quit.")
+		}
+		r.usagef(colorInfo, "Press R or Ctrl-W to rediscover the directories to watch.")
+		if r.replay != nil {
+			r.usagef(colorInfo, "Press o to show the output of the previous run again.")
+		}
+	} else {
+		r.usagef(colorInfo, "To restart the running program, make file changes.")
+	}
+	if err := r.listen(ctx); err != nil {
+		return err
+	}
+	if err := r.serveHTTP(ctx); err != nil {
+		return err
+	}
+	if r.cfg.Tmux {
+		var err error
+		if r.pane, err = r.openPane(); err != nil {
+			return err
+		}
+		if r.pane != nil {
+			r.goroutine(func() { r.resizePane(ctx) })
+		}
+	}
+	r.goroutine(func() { r.probe(ctx) })
+	if r.cfg.PTY {
+		r.goroutine(func() { r.resizePTY(ctx) })
+	}
+	if r.cfg.IdleStop > 0 {
+		r.idle = time.AfterFunc(r.cfg.IdleStop, r.stopIdle)
+	}
+	if r.cfg.EventHook != "" {
+		events, stop := r.events.subscribe()
+		r.goroutine(func() { r.eventHook(ctx, events, stop) })
+	}
+	if len(r.cfg.Notifiers) > 0 {
+		events, stop := r.events.subscribe()
+		r.goroutine(func() { r.notify(ctx, events, stop) })
+	}
+	if r.expected != nil {
+		// subscribe before the command starts, not to miss its exit.
+		events, stop := r.events.subscribe()
+		r.goroutine(func() { r.expect(ctx, events, stop) })
+	}
+	r.goroutine(func() {
 		for {
 			select {
-			case <-r.restart:
-				r.Restart(ctx)
+			case t := <-r.restart:
+				if r.isPaused() && t.reason != "key" {
+					r.debugf("paused, not restarting")
+					continue
+				}
+				r.waitLock(ctx)
+				r.restartFor(ctx, r.coalesce(t))
 			case <-ctx.Done():
 				return
 			}
 		}
+	})
+	// shut down when the context is done or q is pressed.
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-r.quit:
+		}
+		r.Close()
 	}()
 
 	defer func() {
-		r.restart <- true
+		r.send(trigger{reason: "start"})
 	}()
 
 	return r.watch(ctx)
 }
 
+// Interactive reports whether f5 reads keys from the terminal, which it
+// does when its standard input is one.
+func (r *Run) Interactive() bool {
+	return r.term != nil
+}
+
+// isatty reports whether f is a terminal.
+func isatty(f *os.File) bool {
+	var attr unix.Termios
+	return termios.Tcgetattr(f.Fd(), &attr) == nil
+}
+
+// keyRepeat is how long after a restart key the restart waits for another
+// one, as sent by key repeat, to restart once for both.
+const keyRepeat = 100 * time.Millisecond
+
+// ListenForKeys handles key presses until ctx is done. It returns right
+// away when f5 is not interactive.
 func (r *Run) ListenForKeys(ctx context.Context) {
-	r.term.SetCbreak()
+	if r.term == nil {
+		return
+	}
+	r.keyMode()
 	defer r.term.Restore()
+	// restart once a burst of restart keys is over, so that holding F5
+	// or space restarts once rather than on every repeat.
+	var repeat *time.Timer
+	defer func() {
+		if repeat != nil {
+			repeat.Stop()
+		}
+	}()
 	for {
 		if ctx.Err() != nil {
 			return
 		}
-		e, _ := terminput.Read(r.term)
+		e := r.readKey()
+		if e == nil {
+			continue
+		}
 		// log.Printf("got: %s", e.String())
-		switch e.String() {
-		case "DC2":
-			fallthrough
-		case " ":
-			fallthrough
-		case "F5":
-			r.Restart(ctx)
+		if r.answer(e.String()) {
+			continue
+		}
+		key := e.String()
+		switch keyAction(key, r.cfg.SpaceKey) {
+		case "restart":
+			if repeat == nil {
+				repeat = time.AfterFunc(keyRepeat, func() { r.send(trigger{reason: "key"}) })
+			} else {
+				repeat.Reset(keyRepeat)
+			}
+		case "pause":
+			r.togglePause()
+		case "debug":
+			r.toggleDebug()
+		case "rewatch":
+			r.rewatch()
+		case "replay":
+			r.showReplay()
+		case "quit":
+			r.Quit()
+		case "interrupt":
+			// in cbreak mode Ctrl-C usually raises SIGINT, but shut down
+			// the same way when it is read as a key instead.
+			r.mu.Lock()
+			r.exitCode = 128 + int(syscall.SIGINT)
+			r.mu.Unlock()
+			r.Quit()
+		case "scroll":
+			r.scroll(key)
+		case "":
+			if sig, ok := r.keySignals[key]; ok {
+				r.signal(sig)
+			}
 		}
 	}
 }
 
+// keyAction returns what pressing key does, with the space key doing
+// spaceKey, as set by -space-key: "restart", "pause", "debug", "rewatch",
+// "replay", "quit", "interrupt", "scroll", "none" for a space key doing
+// nothing, or "" for a key f5 does not use.
+func keyAction(key, spaceKey string) string {
+	switch key {
+	case " ":
+		if spaceKey == "" {
+			return "restart"
+		}
+		return spaceKey
+	case "F5", "DC2":
+		return "restart"
+	case "p":
+		return "pause"
+	case "d":
+		return "debug"
+	case "R", "ETB":
+		return "rewatch"
+	case "o":
+		return "replay"
+	case "q":
+		return "quit"
+	case "ETX":
+		return "interrupt"
+	case "Up", "Down", "PgUp", "PgDn":
+		return "scroll"
+	}
+	return ""
+}
+
 func (r *Run) watch(ctx context.Context) error {
-	wd, err := os.Getwd()
-	if err != nil {
-		return err
+	dirs, files := r.watchSet(nil)
+	r.usagef(colorInfo, "The following directories are being monitored")
+	for i, d := range dirs {
+		r.usagef(colorInfo, "%3d. %s", i+1, d)
+		r.add(d)
 	}
-	dirs := []string{}
-	filepath.WalkDir(wd, func(s string, d fs.DirEntry, err error) error {
-		if err != nil {
-			return err
-		}
-		if !d.IsDir() {
-			return nil
-		}
-		// skip hidden directories with . as prefix
-		if strings.HasPrefix(filepath.Base(s), ".") {
-			return filepath.SkipDir
+	if len(files) > 0 {
+		r.usagef(colorInfo, "The following files are being monitored")
+		for i, f := range files {
+			r.usagef(colorInfo, "%3d. %s", i+1, f)
+			// watch the directory, as editors often replace the file.
+			r.add(filepath.Dir(f))
 		}
-		// check if the directory has go code.
-		files, err := ioutil.ReadDir(s)
-		if err != nil {
-			return err
-		}
-		for _, f := range files {
-			if supportedExtensionMap[filepath.Ext(f.Name())] {
-				dirs = append(dirs, s)
-				return nil
-			}
-		}
-		return nil
-	})
-	r.usagef(colorWhite, "The following directories are being monitored")
-	for i, d := range dirs {
-		r.usagef(colorWhite, "%3d. %s", i+1, d)
-		r.watcher.Add(d)
+	}
+	if r.resume != nil {
+		r.resumed(dirs, files)
+	}
+	r.goroutine(func() { r.poll(ctx) })
+	if r.cfg.GoSemantic {
+		r.goroutine(func() { r.goSums.prime(ctx, dirs) })
 	}
 
 	// watch until error or cancelled.
-	go func() {
+	r.goroutine(func() {
 		defer r.watcher.Close()
 		for {
 			select {
@@ -219,26 +1215,158 @@ func (r *Run) watch(ctx context.Context) error {
 				return
 			case event, ok := <-r.watcher.Events:
 				if !ok {
-					r.printf(colorRed, "Unknown event, halting.")
+					r.printf(colorError, "Unknown event, halting.")
 					return
 				}
-				if event.Op&fsnotify.Write != fsnotify.Write {
+				r.debugf("event %s %s", event.Op, event.Name)
+				if g := r.gitOf(event.Name); g != nil {
+					r.gitChanged(g, event)
 					continue
 				}
-				if !supportedExtensionMap[filepath.Ext(event.Name)] {
+				if event.Op&fsnotify.Create == fsnotify.Create && isDir(event.Name) {
+					r.watchNew(event.Name)
 					continue
 				}
-				r.printf(colorGreen, "Modified file: %s", event.Name)
-				r.restart <- true
+				if event.Op&fsnotify.Rename == fsnotify.Rename {
+					// the new name comes as a create event, after this one.
+					r.unwatchMoved(event.Name)
+				}
+				if event.Op&fsnotify.Remove == fsnotify.Remove {
+					r.unwatchRemoved(event.Name)
+				}
+				if reason := r.reject(event); reason != "" {
+					r.debugf("rejected %s: %s", event.Name, reason)
+					continue
+				}
+				r.debugf("accepted %s", event.Name)
+				if removed(event) {
+					r.deleted(event.Name)
+					continue
+				}
+				if r.cfg.Settle > 0 {
+					r.settle(event.Name)
+					continue
+				}
+				r.changed(event.Name)
 			case err, ok := <-r.watcher.Errors:
 				if !ok {
-					r.printf(colorRed, "Unknown error, halting.")
+					r.printf(colorError, "Unknown error, halting.")
 					return
 				}
-				r.printf(colorRed, "Error:", err)
+				r.printf(colorError, "Error:", err)
 			}
 		}
-	}()
+	})
 
 	return nil
 }
+
+// removed reports whether the event is about a file deleted or renamed
+// away, rather than changed.
+func removed(event fsnotify.Event) bool {
+	return event.Op&(fsnotify.Remove|fsnotify.Rename) != 0
+}
+
+// tempFile reports whether the file is a backup, lock or autosave file
+// of an editor, which may look like a source file, as ".#main.go" does.
+func tempFile(path string) bool {
+	name := filepath.Base(path)
+	return strings.HasPrefix(name, ".#") ||
+		strings.HasSuffix(name, "~") ||
+		strings.HasPrefix(name, "#") && strings.HasSuffix(name, "#")
+}
+
+// changed restarts the command for a changed file.
+func (r *Run) changed(path string) {
+	r.resumeSeen(path)
+	if path == r.configFile {
+		r.reload()
+		return
+	}
+	if time.Now().Before(r.warmup) {
+		r.debugf("Ignored change during -warmup: %s", path)
+		return
+	}
+	if r.cfg.GoSemantic && filepath.Ext(path) == ".go" && r.goSums.update(path) {
+		r.printf(colorInfo, "Only comments or formatting changed in %s, not restarting", path)
+		return
+	}
+	r.printf(colorSuccess, "Modified file: %s", r.paintPath(colorSuccess, path))
+	r.countChange(path)
+	r.emit(Event{Type: "change", Path: path})
+	r.send(trigger{reason: "change", path: path})
+}
+
+// deleted restarts the command for a deleted file.
+func (r *Run) deleted(path string) {
+	if path == r.configFile {
+		r.reload()
+		return
+	}
+	if time.Now().Before(r.warmup) {
+		r.debugf("Ignored deletion during -warmup: %s", path)
+		return
+	}
+	r.printf(colorSuccess, "Deleted file: %s", r.paintPath(colorSuccess, path))
+	r.countChange(path)
+	r.emit(Event{Type: "change", Path: path, Message: "deleted"})
+	r.send(trigger{reason: "delete", path: path})
+}
+
+// reject returns why the event should not trigger a restart, or an empty
+// string if it should.
+func (r *Run) reject(event fsnotify.Event) string {
+	switch {
+	case event.Op&r.ops == 0:
+		return "not one of -ops"
+	case removed(event):
+		// editors saving by replacing the file remove it first.
+		if _, err := os.Lstat(event.Name); err == nil {
+			return "replaced, not deleted"
+		}
+	}
+	if tempFile(event.Name) {
+		return "editor temporary file"
+	}
+	if event.Name == r.envFile || event.Name == r.configFile {
+		return ""
+	}
+	rt := r.rootOf(event.Name)
+	if rt == nil {
+		return "outside of watched roots"
+	}
+	if rt.file != "" {
+		// watched by itself, whatever its extension.
+		return ""
+	}
+	if rt.ignored(event.Name, false) {
+		return "ignored"
+	}
+	if watch, _ := r.allowed(filepath.Dir(event.Name)); !watch {
+		return "outside of -only-dir"
+	}
+	if !rt.supported(event.Name) {
+		return "unsupported extension"
+	}
+	if r.imports != nil && filepath.Ext(event.Name) == ".go" && !r.imports.imported(event.Name) {
+		return "not imported by the command"
+	}
+	return ""
+}
+
+// scroll moves the view of the panel in -tui mode.
+func (r *Run) scroll(key string) {
+	if r.tui == nil {
+		return
+	}
+	switch key {
+	case "Up":
+		r.tui.scrollBy(1)
+	case "Down":
+		r.tui.scrollBy(-1)
+	case "PgUp":
+		r.tui.scrollPages(1)
+	case "PgDn":
+		r.tui.scrollPages(-1)
+	}
+}
@@ -0,0 +1,176 @@
+package main
+
+import (
+	"bytes"
+	"encoding/json"
+	"flag"
+	"fmt"
+	"os"
+	"sort"
+	"strings"
+)
+
+// defaultConfigFile is the config file read when it exists, unless another
+// one is given with -config.
+const defaultConfigFile = ".f5.json"
+
+// configFile is a JSON file of settings keyed by flag name, as printed by
+// -print-config, with named profiles of more settings:
+//
+//	{
+//	  "ext": [".go", ".tmpl"],
+//	  "settle": "200ms",
+//	  "command": ["go", "run", "."],
+//	  "profiles": {
+//	    "test": {"command": ["go", "test", "./..."], "ignore": ["testdata"]}
+//	  }
+//	}
+type configFile struct {
+	settings map[string]json.RawMessage
+	profiles map[string]map[string]json.RawMessage
+}
+
+func readConfigFile(name string) (*configFile, error) {
+	b, err := os.ReadFile(name)
+	if err != nil {
+		return nil, err
+	}
+	c := &configFile{}
+	if err := json.Unmarshal(b, &c.settings); err != nil {
+		return nil, fmt.Errorf("%s: %v", name, err)
+	}
+	if p, ok := c.settings["profiles"]; ok {
+		if err := json.Unmarshal(p, &c.profiles); err != nil {
+			return nil, fmt.Errorf("%s: profiles: %v", name, err)
+		}
+		delete(c.settings, "profiles")
+	}
+	// derived from ext in the output of -print-config.
+	delete(c.settings, "watched-extensions")
+	return c, nil
+}
+
+// loadConfig applies the settings of the config file, and of the profile
+// if any, to the flags not set on the command line, and returns the command
+// to run when none is given there and the file read, if any. The profile
+// overrides the file, and the command line both.
+func loadConfig(name, profile string) ([]string, string, error) {
+	explicit := name != ""
+	if !explicit {
+		name = defaultConfigFile
+	}
+	c, err := readConfigFile(name)
+	if os.IsNotExist(err) && !explicit && profile == "" {
+		return nil, "", nil
+	}
+	if err != nil {
+		return nil, "", err
+	}
+	layers := []map[string]json.RawMessage{}
+	if profile != "" {
+		p, ok := c.profiles[profile]
+		if !ok {
+			names := []string{}
+			for n := range c.profiles {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return nil, "", fmt.Errorf("unknown profile %q, expect one of %q", profile, names)
+		}
+		layers = append(layers, p)
+	}
+	layers = append(layers, c.settings)
+
+	set := map[string]bool{}
+	flag.Visit(func(f *flag.Flag) { set[f.Name] = true })
+	var command []string
+	for _, layer := range layers {
+		for key, raw := range layer {
+			if key == "command" {
+				if command == nil {
+					if err := json.Unmarshal(raw, &command); err != nil {
+						return nil, "", fmt.Errorf("command: %v", err)
+					}
+				}
+				continue
+			}
+			if flag.Lookup(key) == nil {
+				return nil, "", fmt.Errorf("unknown setting %q", key)
+			}
+			if set[key] {
+				continue
+			}
+			set[key] = true
+			values, err := flagValues(raw)
+			if err != nil {
+				return nil, "", fmt.Errorf("%s: %v", key, err)
+			}
+			for _, v := range values {
+				if err := flag.Set(key, v); err != nil {
+					return nil, "", fmt.Errorf("%s: %v", key, err)
+				}
+			}
+		}
+	}
+	return command, name, nil
+}
+
+// flagValues returns the flag values to set for a JSON value: one for a
+// string, number or boolean, one per element of an array, and one key=value
+// pair per field of an object. A root object, as printed by -print-config,
+// becomes a dir:ext=...:ignore=... value.
+func flagValues(raw json.RawMessage) ([]string, error) {
+	d := json.NewDecoder(bytes.NewReader(raw))
+	d.UseNumber()
+	var v any
+	if err := d.Decode(&v); err != nil {
+		return nil, err
+	}
+	switch v := v.(type) {
+	case nil:
+		return nil, nil
+	case []any:
+		values := []string{}
+		for _, e := range v {
+			if root, ok := e.(map[string]any); ok {
+				s, err := rootValue(root)
+				if err != nil {
+					return nil, err
+				}
+				values = append(values, s)
+				continue
+			}
+			values = append(values, fmt.Sprint(e))
+		}
+		return values, nil
+	case map[string]any:
+		values := []string{}
+		for k, e := range v {
+			values = append(values, fmt.Sprintf("%s=%v", k, e))
+		}
+		sort.Strings(values)
+		return values, nil
+	}
+	return []string{fmt.Sprint(v)}, nil
+}
+
+// rootValue formats a root object as f5.ParseRoot parses it.
+func rootValue(root map[string]any) (string, error) {
+	dir, ok := root["dir"].(string)
+	if !ok {
+		return "", fmt.Errorf("missing dir in %v", root)
+	}
+	parts := []string{dir}
+	for _, key := range []string{"ext", "ignore"} {
+		list, _ := root[key].([]any)
+		if len(list) == 0 {
+			continue
+		}
+		values := []string{}
+		for _, e := range list {
+			values = append(values, fmt.Sprint(e))
+		}
+		parts = append(parts, key+"="+strings.Join(values, ","))
+	}
+	return strings.Join(parts, ":"), nil
+}
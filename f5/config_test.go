@@ -0,0 +1,146 @@
+package main
+
+import (
+	"encoding/json"
+	"flag"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"testing"
+	"time"
+
+	"github.com/yukinying/f5"
+)
+
+// testFlags replaces the command line flags with a few of those of f5,
+// parsed from args, as main defines and parses them.
+func testFlags(t *testing.T, args ...string) *f5.Config {
+	t.Helper()
+	saved := flag.CommandLine
+	t.Cleanup(func() { flag.CommandLine = saved })
+	flag.CommandLine = flag.NewFlagSet("f5", flag.ContinueOnError)
+	cfg := &f5.Config{}
+	flag.Var((*list)(&cfg.Extensions), "ext", "")
+	flag.DurationVar(&cfg.Settle, "settle", 0, "")
+	flag.BoolVar(&cfg.Clear, "clear", false, "")
+	flag.Var((*roots)(&cfg.Roots), "dir", "")
+	if err := flag.CommandLine.Parse(args); err != nil {
+		t.Fatal(err)
+	}
+	return cfg
+}
+
+// writeConfig writes the config file content in a new directory, and
+// returns its path.
+func writeConfig(t *testing.T, content string) string {
+	t.Helper()
+	name := filepath.Join(t.TempDir(), ".f5.json")
+	if err := os.WriteFile(name, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return name
+}
+
+func TestLoadConfigProfile(t *testing.T) {
+	name := writeConfig(t, `{
+		"ext": [".go"],
+		"settle": "1s",
+		"clear": true,
+		"command": ["go", "run", "."],
+		"profiles": {
+			"test": {
+				"settle": "2s",
+				"ext": [".c", ".h"],
+				"command": ["go", "test", "./..."]
+			}
+		}
+	}`)
+	tests := []struct {
+		args    []string
+		profile string
+		command []string
+		ext     []string
+		settle  time.Duration
+	}{
+		{nil, "", []string{"go", "run", "."}, []string{".go"}, time.Second},
+		// the profile overrides the file.
+		{nil, "test", []string{"go", "test", "./..."}, []string{".c", ".h"}, 2 * time.Second},
+		// the command line overrides both.
+		{[]string{"-settle", "3s"}, "test", []string{"go", "test", "./..."}, []string{".c", ".h"}, 3 * time.Second},
+	}
+	for _, tt := range tests {
+		cfg := testFlags(t, tt.args...)
+		command, file, err := loadConfig(name, tt.profile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if file != name {
+			t.Errorf("read %s, want %s", file, name)
+		}
+		if !reflect.DeepEqual(command, tt.command) {
+			t.Errorf("%v -profile %q: command %q, want %q", tt.args, tt.profile, command, tt.command)
+		}
+		if !reflect.DeepEqual([]string(cfg.Extensions), tt.ext) {
+			t.Errorf("%v -profile %q: ext %q, want %q", tt.args, tt.profile, cfg.Extensions, tt.ext)
+		}
+		if cfg.Settle != tt.settle {
+			t.Errorf("%v -profile %q: settle %s, want %s", tt.args, tt.profile, cfg.Settle, tt.settle)
+		}
+		if !cfg.Clear {
+			t.Errorf("%v -profile %q: clear not set from the file", tt.args, tt.profile)
+		}
+	}
+}
+
+func TestLoadConfigErrors(t *testing.T) {
+	tests := []struct {
+		content, profile, err string
+	}{
+		{`{"no-such-flag": true}`, "", `unknown setting "no-such-flag"`},
+		{`{"profiles": {"test": {"nope": 1}}}`, "test", `unknown setting "nope"`},
+		{`{"profiles": {"b": {}, "a": {}}}`, "c", `unknown profile "c", expect one of ["a" "b"]`},
+		{`{"settle": "soon"}`, "", "settle: "},
+	}
+	for _, tt := range tests {
+		testFlags(t)
+		_, _, err := loadConfig(writeConfig(t, tt.content), tt.profile)
+		if err == nil || !strings.Contains(err.Error(), tt.err) {
+			t.Errorf("%s: error %v, want %s", tt.content, err, tt.err)
+		}
+	}
+	// only the default file may be missing.
+	testFlags(t)
+	if _, _, err := loadConfig(filepath.Join(t.TempDir(), "missing.json"), ""); !os.IsNotExist(err) {
+		t.Errorf("missing -config file: error %v, want not found", err)
+	}
+}
+
+// TestPrintConfigRoots checks that the roots printed by -print-config
+// read back as the same roots.
+func TestPrintConfigRoots(t *testing.T) {
+	want := []f5.Root{
+		{Dir: "."},
+		{Dir: "web", Extensions: []string{".ts", ".tsx"}},
+		{Dir: "api", Extensions: []string{".go"}, Ignore: []string{"gen", "*.pb.go"}},
+	}
+	b, err := json.Marshal(f5.Config{Roots: want})
+	if err != nil {
+		t.Fatal(err)
+	}
+	var printed map[string]json.RawMessage
+	if err := json.Unmarshal(b, &printed); err != nil {
+		t.Fatal(err)
+	}
+	cfg := testFlags(t)
+	name := writeConfig(t, `{"dir": `+string(printed["dir"])+`}`)
+	if _, _, err := loadConfig(name, ""); err != nil {
+		t.Fatal(err)
+	}
+	if !reflect.DeepEqual(cfg.Roots, want) {
+		t.Errorf("read back roots %+v, want %+v", cfg.Roots, want)
+	}
+	if _, err := rootValue(map[string]any{"ext": []any{".go"}}); err == nil {
+		t.Error("root without dir read, want an error")
+	}
+}
@@ -2,33 +2,321 @@ package main
 
 import (
 	"context"
+	"encoding/json"
 	"flag"
+	"fmt"
 	"log"
 	"os"
 	"os/signal"
+	"strconv"
+	"strings"
 	"syscall"
+	"time"
 
 	"github.com/yukinying/f5"
 )
 
+// The exit codes of f5, which scripts may rely on.
+const (
+	// exitOK is the code when f5 was quit, or ran out of restarts.
+	exitOK = 0
+	// exitUsage is the code for bad flags, config or arguments.
+	exitUsage = 1
+	// exitSetup is the code when f5 cannot set up watching or start the
+	// command.
+	exitSetup = 2
+	// exitSignal plus the signal number is the code when f5 is stopped by
+	// a signal: 130 for Ctrl-C, 143 for SIGTERM.
+	exitSignal = 128
+)
+
 func main() {
-	ctx := context.Background()
-	flag.Parse()
+	// run as the helper starting the command, if f5 started itself so.
+	f5.RunHelper()
+	// shut down on Ctrl-C, etc.
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	signals := make(chan os.Signal, 1)
+	signal.Notify(signals, os.Interrupt, syscall.SIGTERM)
+	var caught os.Signal
+	go func() {
+		caught = <-signals
+		cancel()
+	}()
+	var cfg f5.Config
+	listExt := flag.Bool("list-ext", false, "print the watched extensions and exit")
+	printConfig := flag.Bool("print-config", false, "print the resolved settings as JSON and exit")
+	configName := flag.String("config", "", "JSON file of settings keyed by flag name, and of named profiles (default \""+defaultConfigFile+"\" if it exists)")
+	profile := flag.String("profile", "", "apply the settings and command of this profile of the config file")
+	flag.Var((*list)(&cfg.Extensions), "ext", "comma separated extensions to watch in addition to the defaults, or to stop watching with a - prefix")
+	flag.Var((*roots)(&cfg.Roots), "dir", "directory to watch, as dir[:ext=.go,.c][:ignore=pattern,...]; repeatable, all restarting the one command")
+	flag.Var((*list)(&cfg.AlsoWatch), "also-watch", "comma separated directories or files to watch in addition to the roots; repeatable")
+	flag.Var((*list)(&cfg.IgnoreFiles), "ignore-files", "comma separated ignore files to read in every directory along with .f5ignore, such as .gitignore,.ignore")
+	flag.StringVar(&cfg.EnvFile, "env-file", f5.DefaultEnvFile, "file of KEY=VALUE lines to add to the command's environment, if it exists; empty to disable")
+	flag.BoolVar(&cfg.CleanEnv, "clean-env", false, "run the command with only the variables of -env-keep and -env-file; without PATH most commands break")
+	flag.Var((*list)(&cfg.EnvKeep), "env-keep", "comma separated variables of f5's environment to keep with -clean-env, such as PATH,HOME")
+	flag.BoolVar(&cfg.RunEnv, "run-env", false, "set F5_RUN, F5_REASON and F5_TRIGGER in the command's environment to its run number, why it started and the changed file")
+	flag.StringVar(&cfg.PortEnv, "port-env", "", "set this environment variable, such as PORT, to a free port on each start; the command must listen on it")
+	flag.BoolVar(&cfg.BlueGreen, "blue-green", false, "keep the previous run until the new one is ready, with -ready-regex and -port-env")
+	flag.StringVar(&cfg.PIDFile, "pid-file", "", "write the pid of the running command to this file")
+	maxDepth := flag.Int("max-depth", -1, "levels of directories to watch below each root, 0 for the root only; -1 means unlimited")
+	flag.Var((*list)(&cfg.OnlyDirs), "only-dir", "watch only inside these comma separated directories; repeatable")
+	flag.BoolVar(&cfg.All, "all", false, "watch all files whatever their extension; pair with -ignore for files the command writes")
+	flag.BoolVar(&cfg.WatchManifests, "watch-manifests", false, "also watch dependency manifests such as go.mod, package.json and Cargo.toml")
+	flag.BoolVar(&cfg.DetectShebang, "detect-shebang", false, "also watch extensionless scripts with a shebang line")
+	flag.DurationVar(&cfg.Settle, "settle", 0, "restart only once a changed file stopped changing for this long")
+	flag.DurationVar(&cfg.Warmup, "warmup", 0, "ignore file changes for this long after startup")
+	flag.BoolVar(&cfg.GoSemantic, "go-semantic", false, "experimental: do not restart for changes to comments or formatting of Go files")
+	flag.BoolVar(&cfg.ImportAware, "import-aware", false, "with go run or go build, restart only for Go files of the packages the program imports")
+	flag.BoolVar(&cfg.GoStatus, "go-status", false, "with go run, run the program built through f5 to report its exit code rather than go run's")
+	flag.BoolVar(&cfg.RestartOnDelete, "restart-on-delete", false, "also restart when a watched file is deleted")
+	flag.Var((*list)(&cfg.Ops), "ops", "comma separated operations on watched files that restart the command: write, create, remove, rename, chmod or all (default write)")
+	flag.BoolVar(&cfg.Resume, "resume", false, "report the watched files changed since f5 last ran in this directory, kept in f5/resume-*.json in the user cache directory")
+	flag.BoolVar(&cfg.WatchGit, "watch-git", false, "also restart when the git commit checked out changes")
+	flag.Var((*list)(&cfg.Include), "include", "comma separated patterns of files to watch instead of the extensions, as in .f5watch")
+	flag.Var((*list)(&cfg.Ignore), "ignore", "comma separated patterns of files and directories to ignore, as in .gitignore")
+	flag.StringVar(&cfg.User, "user", "", "run the command as this user, by name or id; needs root")
+	flag.StringVar(&cfg.Group, "group", "", "run the command as this group, by name or id, instead of the user's; needs root")
+	flag.Var((*mapping)(&cfg.Limits), "limit", "comma separated resource=value limits of the command, such as nofile=1024,as=512MB; repeatable")
+	flag.DurationVar(&cfg.ForceAfter, "force-after", f5.DefaultForceAfter, "kill the command when it has not exited this long after being interrupted")
+	flag.BoolVar(&cfg.NoForce, "no-force", false, "never kill the command when it ignores being interrupted, only warn while it runs")
+	flag.BoolVar(&cfg.NoProcessGroup, "no-pgid", false, "do not run the command in its own process group; processes it spawns may be orphaned")
+	flag.StringVar(&cfg.TriggerCommand, "trigger-cmd", "", "shell command to poll, restarting when its output changes")
+	flag.DurationVar(&cfg.TriggerInterval, "trigger-interval", 2*time.Second, "how often to run -trigger-cmd")
+	flag.StringVar(&cfg.Wrap, "wrap", "", "run the command under this wrapper command, such as \"strace -f\"")
+	flag.StringVar(&cfg.Build, "build", "", "shell command to run before each start; the previous run goes on if it fails")
+	flag.Var((*list)(&cfg.RebuildExt), "rebuild-ext", "comma separated extensions whose changes run -build; other changes restart without it")
+	flag.Var((*list)(&cfg.ReloadExt), "reload-ext", "comma separated extensions, such as .html,.tmpl, whose changes send -reload-signal instead of restarting")
+	flag.StringVar(&cfg.ReloadSignal, "reload-signal", "HUP", "signal sent to the command for changes to -reload-ext files")
+	flag.StringVar(&cfg.LockFile, "lock-file", "", "hold off restarting while this file exists, then restart once")
+	flag.DurationVar(&cfg.LockTimeout, "lock-timeout", time.Minute, "restart anyway when -lock-file still exists after this long; 0 waits forever")
+	flag.StringVar(&cfg.OnStart, "on-start", "", "shell command to run and wait for after each start of the command, such as a smoke test")
+	flag.DurationVar(&cfg.OnStartTimeout, "on-start-timeout", 30*time.Second, "stop -on-start after this long; 0 waits as long as it runs")
+	flag.StringVar(&cfg.Cleanup, "cleanup", "", "shell command to run on shutdown, after the command is stopped")
+	flag.BoolVar(&cfg.EchoCommand, "echo-cmd", false, "print the command, quoted to paste into a shell, on each start")
+	flag.DurationVar(&cfg.IdleStop, "idle-stop", 0, "stop the command after this long without changes, and start it again on the next change")
+	flag.BoolVar(&cfg.Clear, "clear", false, "clear the screen before each restart, keeping the startup messages on the first run")
+	flag.BoolVar(&cfg.GroupOutput, "group-output", false, "bracket the output of each run with begin and end banners")
+	flag.StringVar(&cfg.Socket, "sock", "", "listen on this Unix socket for restart, status and quit commands")
+	flag.StringVar(&cfg.EventHook, "event-hook", "", "shell command kept running that reads the events as JSON lines on its standard input")
+	notify := flag.Bool("notify", false, "show a desktop notification when the command fails")
+	webhooks := list{}
+	flag.Var(&webhooks, "webhook", "comma separated URLs to post each start, exit and error to as JSON; repeatable")
+	flag.Var((*list)(&cfg.Confirm), "confirm", "comma separated reasons to ask before restarting for: key, change, delete, signal, control, trigger-cmd, git, exit or all")
+	flag.DurationVar(&cfg.ConfirmTimeout, "confirm-timeout", 10*time.Second, "how long -confirm waits for an answer before not restarting; 0 waits forever")
+	flag.Var((*codes)(&cfg.RestartOnExit), "restart-on-exit", "comma separated exit codes, such as 75, after which the command is started again right away")
+	flag.IntVar(&cfg.Count, "count", 0, "quit instead of restarting after this many restarts; 0 means unlimited")
+	flag.StringVar(&cfg.SpaceKey, "space-key", "restart", "what the space key does: restart, pause or none; F5 and Ctrl-R always restart")
+	flag.Var((*mapping)(&cfg.KeySignals), "key-signal", "comma separated key=signal pairs sending signals to the command, such as 1=USR1")
+	flag.StringVar(&cfg.ReadyRegex, "ready-regex", "", "report the command ready once a line of its output matches this regular expression")
+	flag.DurationVar(&cfg.ReadyTimeout, "ready-timeout", 30*time.Second, "warn when the command is not ready this long after starting; 0 never warns")
+	flag.StringVar(&cfg.OnReady, "on-ready", "", "shell command to run each time the command is ready")
+	flag.StringVar(&cfg.WarmupURL, "warmup-url", "", "URL requested once the command is ready, to prime the server, such as http://localhost:8080/")
+	flag.StringVar(&cfg.WarmupMethod, "warmup-method", "GET", "method of the -warmup-url request")
+	flag.StringVar(&cfg.WarmupBody, "warmup-body", "", "body of the -warmup-url request")
+	flag.StringVar(&cfg.Expect, "expect", "", "run the command once, and exit 0 when its output contains this string or 3 when it does not")
+	flag.DurationVar(&cfg.ExpectTimeout, "expect-timeout", 30*time.Second, "how long -expect waits for the output; 0 waits until the command exits")
+	flag.IntVar(&cfg.Replay, "replay", 0, "lines of output of the previous run kept to show again with o, such as 200; the command's output is piped through f5 then")
+	flag.BoolVar(&cfg.Summary, "summary", false, "print the restarts, crashes and most changed files on shutdown")
+	flag.StringVar(&cfg.Label, "label", "", "prefix f5's output with this label instead of the command name")
+	flag.StringVar(&cfg.Theme, "theme", "", "color theme: "+strings.Join(f5.Themes(), ", "))
+	flag.StringVar(&cfg.Color, "color", "auto", "when to color f5's output: auto when it is a terminal and NO_COLOR is not set, always, or never")
+	noColor := flag.Bool("no-color", false, "disable colors, same as -color never")
+	flag.StringVar(&cfg.Listen, "listen", "", "address of an HTTP server for -metrics, such as localhost:9090")
+	flag.BoolVar(&cfg.Metrics, "metrics", false, "serve restart and uptime counters on /metrics of -listen")
+	flag.Var((*mapping)(&cfg.ExtColors), "ext-color", "comma separated ext=color pairs coloring changed file names, such as .go=cyan")
+	flag.BoolVar(&cfg.TUI, "tui", false, "show a full screen panel with status and recent output")
+	flag.BoolVar(&cfg.Stdin, "stdin", false, "pass the terminal to the command while it runs, for interactive programs; f5 keys work only while it is not running")
+	flag.BoolVar(&cfg.PTY, "pty", false, "run the command on a pseudo-terminal, so it behaves as when run interactively")
+	flag.BoolVar(&cfg.TimestampOutput, "ts-output", false, "prefix each line of the command's output with the time it was written")
+	flag.StringVar(&cfg.TimestampFormat, "ts-format", f5.DefaultTimestampFormat, "Go time layout of the -ts-output timestamps")
+	flag.BoolVar(&cfg.Tmux, "tmux", false, "run the command in a new tmux pane, for full screen programs; needs f5 to run in tmux")
+	flag.BoolVar(&cfg.MergeOutput, "merge-output", false, "send the command's error output to its output, in order")
+	flag.StringVar(&cfg.Tee, "tee", "", "also write the command's output to this file, truncated on each restart")
+	flag.BoolVar(&cfg.TeeAppend, "tee-append", false, "append to the -tee file across restarts instead of truncating it")
+	flag.IntVar(&cfg.MaxOutputRate, "max-output-rate", 0, "show at most this many lines of output a second, dropping the rest; 0 means unlimited")
+	flag.IntVar(&cfg.MaxOutputBytes, "max-output-bytes", 1<<20, "bound the output held in memory to this many bytes; 0 means unlimited")
+	flag.BoolVar(&cfg.LineBuffered, "line-buffered", false, "pass the command's output on line by line")
+	flag.BoolVar(&cfg.Debug, "debug", false, "log every file event and why it was accepted or rejected")
+	flag.Usage = usage
+	flag.CommandLine.Init(os.Args[0], flag.ContinueOnError)
+	// flag parsing stops at the first non-flag argument or after "--", so
+	// "f5 -debug -- go run -race ." passes "-race" to the command.
+	if err := flag.CommandLine.Parse(os.Args[1:]); err == flag.ErrHelp {
+		os.Exit(exitOK)
+	} else if err != nil {
+		os.Exit(exitUsage)
+	}
+	// the config file fills in what the command line does not set.
+	command, file, err := loadConfig(*configName, *profile)
+	if err != nil {
+		fatalf(exitUsage, "cannot load config: %v", err)
+	}
+	cfg.ConfigFile = file
+	args := flag.Args()
+	if len(args) == 0 {
+		args = command
+	}
+	if *notify {
+		cfg.Notifiers = append(cfg.Notifiers, f5.DesktopNotifier())
+	}
+	for _, url := range webhooks {
+		cfg.Notifiers = append(cfg.Notifiers, f5.WebhookNotifier(url))
+	}
+	if *noColor {
+		cfg.Color = "never"
+	}
+	if *maxDepth >= 0 {
+		cfg.MaxDepth = maxDepth
+	}
+	if *printConfig {
+		b, err := json.MarshalIndent(cfg.Resolved(), "", "  ")
+		if err != nil {
+			fatalf(exitUsage, "cannot print config: %v", err)
+		}
+		fmt.Println(string(b))
+		return
+	}
+	if *listExt {
+		for _, e := range cfg.WatchedExtensions() {
+			fmt.Println(e)
+		}
+		return
+	}
+	if len(args) == 0 {
+		usage()
+		os.Exit(exitUsage)
+	}
 	// initialize.
-	r, err := f5.New(flag.Args()...)
+	r, err := f5.NewWithConfig(cfg, args...)
 	if err != nil {
-		log.Fatalf("cannot create f5: %v", err)
+		fatalf(exitSetup, "cannot create f5: %v", err)
 	}
 	// start the program.
 	if err := r.Start(ctx); err != nil {
-		log.Fatalf("cannot run: %v", err)
-	}
-	// listen for F5 or space key.
-	go r.ListenForKeys(ctx)
-	// wait for Ctrl-C, etc.
-	done := make(chan os.Signal, 1)
-	signal.Notify(done, os.Interrupt, syscall.SIGINT, syscall.SIGTERM)
-	<-done
-	// close the runner.
-	r.Close()
+		fatalf(exitSetup, "cannot run: %v", err)
+	}
+	// restart on SIGUSR1, pause on SIGUSR2.
+	go r.ListenForSignals(ctx)
+	// listen for F5 or space key, unless run from a script.
+	if r.Interactive() {
+		go r.ListenForKeys(ctx)
+	}
+	// wait until shut down by Ctrl-C, q, etc.
+	r.Wait()
+	if r.Reloading() {
+		// start over with the settings of the config file as changed.
+		self, err := os.Executable()
+		if err == nil {
+			err = syscall.Exec(self, os.Args, os.Environ())
+		}
+		fatalf(exitSetup, "cannot reload config: %v", err)
+	}
+	if sig, ok := caught.(syscall.Signal); ok {
+		os.Exit(exitSignal + int(sig))
+	}
+	os.Exit(r.ExitCode())
+}
+
+// fatalf logs the error and exits with code.
+func fatalf(code int, format string, args ...any) {
+	log.Printf(format, args...)
+	os.Exit(code)
+}
+
+func usage() {
+	fmt.Fprintf(flag.CommandLine.Output(), "Usage: %s [flags] [--] [command [args...]]\n", os.Args[0])
+	flag.PrintDefaults()
+	fmt.Fprint(flag.CommandLine.Output(), `
+Signals:
+  SIGUSR1          restart the command
+  SIGUSR2          pause or resume watching
+  SIGINT, SIGTERM  stop the command and exit
+
+Exit codes:
+  0                quit, or ran out of restarts with -count
+  1                bad flags, config or arguments
+  2                cannot watch the directories or run the command
+  3                the output of -expect not found
+  128+N            stopped by signal N: 130 for Ctrl-C, 143 for SIGTERM
+`)
+}
+
+// roots is a repeatable flag of watched directories.
+type roots []f5.Root
+
+func (rs *roots) String() string {
+	dirs := []string{}
+	for _, r := range *rs {
+		dirs = append(dirs, r.Dir)
+	}
+	return strings.Join(dirs, ",")
+}
+
+func (rs *roots) Set(s string) error {
+	r, err := f5.ParseRoot(s)
+	if err != nil {
+		return err
+	}
+	*rs = append(*rs, r)
+	return nil
+}
+
+// list is a repeatable flag of comma separated values.
+type list []string
+
+func (l *list) String() string {
+	return strings.Join(*l, ",")
+}
+
+func (l *list) Set(s string) error {
+	*l = append(*l, strings.Split(s, ",")...)
+	return nil
+}
+
+// mapping is a repeatable flag of comma separated key=value pairs.
+type mapping map[string]string
+
+func (m *mapping) String() string {
+	pairs := []string{}
+	for k, v := range *m {
+		pairs = append(pairs, k+"="+v)
+	}
+	return strings.Join(pairs, ",")
+}
+
+func (m *mapping) Set(s string) error {
+	if *m == nil {
+		*m = mapping{}
+	}
+	for _, p := range strings.Split(s, ",") {
+		k, v, ok := strings.Cut(p, "=")
+		if !ok {
+			return fmt.Errorf("expect key=value, got %q", p)
+		}
+		(*m)[k] = v
+	}
+	return nil
+}
+
+// codes is a repeatable flag of comma separated exit codes.
+type codes []int
+
+func (c *codes) String() string {
+	s := []string{}
+	for _, n := range *c {
+		s = append(s, strconv.Itoa(n))
+	}
+	return strings.Join(s, ",")
+}
+
+func (c *codes) Set(s string) error {
+	for _, v := range strings.Split(s, ",") {
+		n, err := strconv.Atoi(v)
+		if err != nil || n < 0 || n > 255 {
+			return fmt.Errorf("expect exit codes from 0 to 255, got %q", v)
+		}
+		*c = append(*c, n)
+	}
+	return nil
 }
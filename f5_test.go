@@ -0,0 +1,420 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"github.com/fsnotify/fsnotify"
+	"github.com/pkg/term"
+	"golang.org/x/sys/unix"
+)
+
+// TestMain lets the test binary run as the helper f5 starts the command
+// through, as the f5 command does.
+func TestMain(m *testing.M) {
+	RunHelper()
+	os.Exit(m.Run())
+}
+
+// TestIdleStopReload checks that changes sending the -reload-signal keep
+// the command from being stopped as idle, as restarts do.
+func TestIdleStopReload(t *testing.T) {
+	r := newTestRun(t, Config{
+		IdleStop:       300 * time.Millisecond,
+		ReloadExt:      []string{".html"},
+		ReloadSignal:   "WINCH",
+		NoProcessGroup: true,
+	})
+	cmd := exec.Command("sleep", "10")
+	if err := cmd.Start(); err != nil {
+		t.Fatal(err)
+	}
+	p := &proc{Process: cmd.Process, done: make(chan struct{})}
+	go func() {
+		cmd.Wait()
+		close(p.done)
+	}()
+	t.Cleanup(func() { cmd.Process.Kill() })
+	r.proc = p
+	r.idle = time.AfterFunc(r.cfg.IdleStop, r.stopIdle)
+
+	for i := 0; i < 6; i++ {
+		time.Sleep(100 * time.Millisecond)
+		r.restartFor(context.Background(), trigger{reason: "change", path: "index.html"})
+	}
+	if got, _ := r.status(); got != p || p.exited() {
+		t.Fatal("command stopped as idle while reloaded")
+	}
+	select {
+	case <-p.done:
+	case <-time.After(2 * time.Second):
+		t.Fatal("command not stopped once idle")
+	}
+}
+
+func TestKeyAction(t *testing.T) {
+	tests := []struct {
+		key, spaceKey, want string
+	}{
+		{"F5", "", "restart"},
+		{"DC2", "", "restart"},
+		{" ", "", "restart"},
+		{" ", "pause", "pause"},
+		{" ", "none", "none"},
+		{"F5", "pause", "restart"},
+		{"p", "", "pause"},
+		{"d", "", "debug"},
+		{"R", "", "rewatch"},
+		{"ETB", "", "rewatch"},
+		{"o", "", "replay"},
+		{"q", "", "quit"},
+		{"ETX", "", "interrupt"},
+		{"PgUp", "", "scroll"},
+		{"Down", "", "scroll"},
+		{"x", "", ""},
+		{"r", "", ""},
+	}
+	for _, tt := range tests {
+		if got := keyAction(tt.key, tt.spaceKey); got != tt.want {
+			t.Errorf("keyAction(%q, %q) = %q, want %q", tt.key, tt.spaceKey, got, tt.want)
+		}
+	}
+}
+
+// listenForKeys runs r.ListenForKeys on a pseudo-terminal, returning its
+// other end to type keys into. Ctrl-C is read as a key, as it is when the
+// terminal does not raise SIGINT for it.
+func listenForKeys(t *testing.T, r *Run) *os.File {
+	t.Helper()
+	keys, tty, err := pty.Open()
+	if err != nil {
+		t.Skip(err)
+	}
+	if r.term, err = term.Open(tty.Name()); err != nil {
+		t.Fatal(err)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	done := make(chan struct{})
+	go func() {
+		r.ListenForKeys(ctx)
+		close(done)
+	}()
+	t.Cleanup(func() {
+		cancel()
+		keys.Close()
+		<-done
+		tty.Close()
+	})
+	// wait for cbreak mode, then take the signals off.
+	for {
+		a, err := unix.IoctlGetTermios(int(tty.Fd()), unix.TCGETS)
+		if err != nil {
+			t.Fatal(err)
+		}
+		if a.Lflag&unix.ICANON == 0 {
+			a.Lflag &^= unix.ISIG
+			if err := unix.IoctlSetTermios(int(tty.Fd()), unix.TCSETS, a); err != nil {
+				t.Fatal(err)
+			}
+			return keys
+		}
+		time.Sleep(time.Millisecond)
+	}
+}
+
+func TestListenForKeysInterrupt(t *testing.T) {
+	r := newTestRun(t, Config{})
+	keys := listenForKeys(t, r)
+	if _, err := keys.Write([]byte{3}); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-r.quit:
+	case <-time.After(5 * time.Second):
+		t.Fatal("Ctrl-C did not shut down")
+	}
+	if got := r.ExitCode(); got != 130 {
+		t.Errorf("exit code %d, want 130", got)
+	}
+}
+
+func TestListenForKeysRepeat(t *testing.T) {
+	r := newTestRun(t, Config{})
+	keys := listenForKeys(t, r)
+	// a held space key repeats faster than keyRepeat.
+	for i := 0; i < 10; i++ {
+		if _, err := keys.Write([]byte(" ")); err != nil {
+			t.Fatal(err)
+		}
+		time.Sleep(keyRepeat / 5)
+	}
+	time.Sleep(3 * keyRepeat)
+	if n := len(r.restart); n != 1 {
+		t.Errorf("%d restarts for a held key, want 1", n)
+	}
+	keys.Write([]byte("\x1b[15~")) // F5
+	time.Sleep(3 * keyRepeat)
+	if n := len(r.restart); n != 2 {
+		t.Errorf("%d restarts after F5, want 2", n)
+	}
+}
+
+func TestCloseDrainsRestarts(t *testing.T) {
+	r := newTestRun(t, Config{})
+	for i := 0; i < 3; i++ {
+		r.send(trigger{reason: "change", path: "a.go"})
+	}
+	r.Close()
+	r.send(trigger{reason: "key"})
+	if n := len(r.restart); n != 0 {
+		t.Errorf("%d restarts pending after Close, want none", n)
+	}
+	r.Restart(context.Background())
+	if p, runs := r.status(); p != nil || runs != 0 {
+		t.Errorf("started %d times after Close, want none", runs)
+	}
+}
+
+func TestCoalesce(t *testing.T) {
+	r := newTestRun(t, Config{ReloadExt: []string{".html"}, RebuildExt: []string{".go"}, Color: "never"})
+	var out bytes.Buffer
+	r.logger = log.New(&out, "", 0)
+	for _, path := range []string{"a.html", "b.go", "c.html"} {
+		r.send(trigger{reason: "change", path: path})
+	}
+	got := r.coalesce(<-r.restart)
+	if got.path != "c.html" || got.merged != 2 || !got.build || !got.restart {
+		t.Errorf("coalesced %+v, want c.html merged 2 with build and restart", got)
+	}
+	r.coalesced(got)
+	if want := "Coalesced 3 changes into 1 restart\n"; out.String() != want {
+		t.Errorf("reported %q, want %q", out.String(), want)
+	}
+
+	// more than the restart channel holds.
+	out.Reset()
+	for i := 0; i < cap(r.restart)+2; i++ {
+		r.send(trigger{reason: "key"})
+	}
+	r.coalesced(r.coalesce(<-r.restart))
+	if want := "Coalesced 102 triggers into 1 restart, 2 of them dropped while the restart queue was full\n"; out.String() != want {
+		t.Errorf("reported %q, want %q", out.String(), want)
+	}
+
+	out.Reset()
+	r.coalesced(trigger{reason: "change", path: "a.go"})
+	if out.Len() != 0 {
+		t.Errorf("reported %q for a single change, want nothing", out.String())
+	}
+}
+
+// TestRejectSettingsFiles checks that changes to the environment and config
+// files restart the command, whatever the extensions watched.
+func TestRejectSettingsFiles(t *testing.T) {
+	dir := t.TempDir()
+	env := writeFile(t, dir, ".env", "A=1\n")
+	config := writeFile(t, dir, ".f5.json", "{}\n")
+	r := newTestRun(t, Config{
+		Roots:      []Root{{Dir: dir}},
+		Extensions: []string{".go"},
+		EnvFile:    env,
+		ConfigFile: config,
+	})
+	tests := map[string]string{
+		env:                             "",
+		config:                          "",
+		filepath.Join(dir, "main.go"):   "",
+		filepath.Join(dir, "notes.txt"): "unsupported extension",
+	}
+	for name, want := range tests {
+		if got := r.reject(fsnotify.Event{Name: name, Op: fsnotify.Write}); got != want {
+			t.Errorf("reject(%s) = %q, want %q", filepath.Base(name), got, want)
+		}
+	}
+}
+
+// TestEditEnvFile checks that editing the environment file restarts the
+// command with the new environment.
+func TestEditEnvFile(t *testing.T) {
+	dir := t.TempDir()
+	env := writeFile(t, dir, ".env", "F5_TEST_VALUE=old\n")
+	r, err := NewWithConfig(Config{
+		Roots:      []Root{{Dir: dir}},
+		Extensions: []string{".go"},
+		EnvFile:    env,
+	}, "sh", "-c", "echo $F5_TEST_VALUE")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.watcher.Close() })
+	got := watchRestarts(t, r, func() {
+		f, err := os.OpenFile(env, os.O_APPEND|os.O_WRONLY, 0)
+		if err != nil {
+			t.Fatal(err)
+		}
+		f.WriteString("F5_TEST_VALUE=new\n")
+		f.Close()
+	})
+	if len(got) != 1 || got[0].path != env {
+		t.Fatalf("restarts %+v, want one for %s", got, env)
+	}
+	var out bytes.Buffer
+	if err := r.command(got[0], &out, &out).Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "new\n" {
+		t.Errorf("restarted command saw %q, want %q", out.String(), "new\n")
+	}
+}
+
+// TestDeleteConfigFile checks that deleting the config file with
+// -restart-on-delete reloads the settings rather than restarting the
+// command.
+func TestDeleteConfigFile(t *testing.T) {
+	dir := t.TempDir()
+	config := writeFile(t, dir, ".f5.json", "{}\n")
+	r := newTestRun(t, Config{
+		Roots:           []Root{{Dir: dir}},
+		ConfigFile:      config,
+		RestartOnDelete: true,
+		Color:           "never",
+	})
+	r.logger = log.New(io.Discard, "", 0)
+	got := watchRestarts(t, r, func() { os.Remove(config) })
+	if len(got) != 0 {
+		t.Errorf("restarts %+v, want none", got)
+	}
+	if !r.Reloading() {
+		t.Error("not reloading after deleting the config file")
+	}
+}
+
+func TestTransient(t *testing.T) {
+	tests := map[error]bool{
+		&os.PathError{Op: "fork/exec", Path: "app", Err: syscall.ETXTBSY}: true,
+		syscall.EAGAIN:   true,
+		exec.ErrNotFound: false,
+		os.ErrPermission: false,
+		&os.PathError{Op: "fork/exec", Path: "app", Err: syscall.EACCES}: false,
+	}
+	for err, want := range tests {
+		if got := transient(err); got != want {
+			t.Errorf("transient(%v) = %v, want %v", err, got, want)
+		}
+	}
+}
+
+// TestStartRetries checks that starting a binary still open for writing,
+// as right after a build, is retried until it succeeds.
+func TestStartRetries(t *testing.T) {
+	dir := t.TempDir()
+	bin := filepath.Join(dir, "app")
+	f, err := os.OpenFile(bin, os.O_CREATE|os.O_WRONLY, 0o755)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f.WriteString("#!/bin/sh\n")
+	time.AfterFunc(2*launchBackoff, func() { f.Close() })
+
+	r, err := NewWithConfig(Config{Roots: []Root{{Dir: dir}}}, bin)
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.watcher.Close() })
+	var out bytes.Buffer
+	r.logger = log.New(&out, "", 0)
+	cmd, _, err := r.start(trigger{reason: "start"}, io.Discard, io.Discard)
+	if err != nil {
+		t.Fatalf("start: %v", err)
+	}
+	cmd.Wait()
+	if !strings.Contains(out.String(), "text file busy, retrying") {
+		t.Errorf("reported %q, want a retry", out.String())
+	}
+
+	// a command that cannot run is not retried.
+	os.Chmod(bin, 0o644)
+	out.Reset()
+	if _, _, err := r.start(trigger{reason: "start"}, io.Discard, io.Discard); err == nil {
+		t.Error("started a file that is not executable")
+	}
+	if out.Len() > 0 {
+		t.Errorf("reported %q, want no retry", out.String())
+	}
+}
+
+func TestWrap(t *testing.T) {
+	r, err := NewWithConfig(Config{Wrap: "env F5_WRAPPED=yes"}, "sh", "-c", "echo $F5_WRAPPED")
+	if err != nil {
+		t.Fatal(err)
+	}
+	t.Cleanup(func() { r.watcher.Close() })
+	var out bytes.Buffer
+	cmd := r.command(trigger{reason: "start"}, &out, &out)
+	if want := []string{"env", "F5_WRAPPED=yes", "sh", "-c", "echo $F5_WRAPPED"}; !reflect.DeepEqual(cmd.Args, want) {
+		t.Errorf("args %q, want %q", cmd.Args, want)
+	}
+	if err := cmd.Run(); err != nil {
+		t.Fatal(err)
+	}
+	if out.String() != "yes\n" {
+		t.Errorf("output %q, want the command run by the wrapper", out.String())
+	}
+}
+
+// watchRestarts watches the roots of r until the test ends, and returns
+// the restarts the changes made in the meantime trigger.
+func watchRestarts(t *testing.T, r *Run, change func()) []trigger {
+	t.Helper()
+	ctx, cancel := context.WithCancel(context.Background())
+	t.Cleanup(cancel)
+	if err := r.watch(ctx); err != nil {
+		t.Fatal(err)
+	}
+	change()
+	return restarts(r)
+}
+
+// restarts returns the restarts queued until none came for a while.
+func restarts(r *Run) []trigger {
+	got := []trigger{}
+	for {
+		select {
+		case tr := <-r.restart:
+			got = append(got, tr)
+		case <-time.After(300 * time.Millisecond):
+			return got
+		}
+	}
+}
+
+func TestRestartOnDelete(t *testing.T) {
+	for _, onDelete := range []bool{false, true} {
+		dir := t.TempDir()
+		main := writeFile(t, dir, "main.go", "package main\n")
+		lock := writeFile(t, dir, ".#main.go", "")
+		r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}, RestartOnDelete: onDelete})
+		got := watchRestarts(t, r, func() {
+			os.Remove(lock)
+			os.Remove(main)
+		})
+		want := []trigger{}
+		if onDelete {
+			want = append(want, trigger{reason: "delete", path: main})
+		}
+		if !reflect.DeepEqual(got, want) {
+			t.Errorf("-restart-on-delete=%v: restarts %+v, want %+v", onDelete, got, want)
+		}
+	}
+}
@@ -0,0 +1,205 @@
+package f5
+
+import (
+	"bufio"
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// gitRepo is a git repository whose checked out commit is watched, for
+// -watch-git.
+type gitRepo struct {
+	// dir is the git directory holding HEAD, and common the one holding
+	// the refs, which differ in a linked worktree.
+	dir    string
+	common string
+
+	mu   sync.Mutex
+	ref  string
+	hash string
+}
+
+// findGit returns the repository dir is in, or nil when there is none.
+func findGit(dir string) (*gitRepo, error) {
+	for d := dir; ; d = filepath.Dir(d) {
+		path := filepath.Join(d, ".git")
+		fi, err := os.Stat(path)
+		if err == nil {
+			if !fi.IsDir() {
+				// a linked worktree or submodule points to its git
+				// directory.
+				if path, err = gitFile(path); err != nil {
+					return nil, err
+				}
+			}
+			g := &gitRepo{dir: path, common: path}
+			if b, err := os.ReadFile(filepath.Join(path, "commondir")); err == nil {
+				g.common = strings.TrimSpace(string(b))
+				if !filepath.IsAbs(g.common) {
+					g.common = filepath.Join(path, g.common)
+				}
+			}
+			g.ref, g.hash = g.head()
+			return g, nil
+		}
+		if filepath.Dir(d) == d {
+			return nil, nil
+		}
+	}
+}
+
+// findGits returns the repositories the roots are in, at least one.
+func findGits(roots []*root) ([]*gitRepo, error) {
+	gits := []*gitRepo{}
+	seen := map[string]bool{}
+	for _, rt := range roots {
+		if rt.file != "" {
+			continue
+		}
+		g, err := findGit(rt.dir)
+		if err != nil {
+			return nil, err
+		}
+		if g != nil && !seen[g.dir] {
+			seen[g.dir] = true
+			gits = append(gits, g)
+		}
+	}
+	if len(gits) == 0 {
+		return nil, fmt.Errorf("no git repository to watch")
+	}
+	return gits, nil
+}
+
+// gitFile returns the git directory a .git file points to.
+func gitFile(path string) (string, error) {
+	b, err := os.ReadFile(path)
+	if err != nil {
+		return "", err
+	}
+	line := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(line, "gitdir: ") {
+		return "", fmt.Errorf("%s: expect gitdir: line", path)
+	}
+	dir := strings.TrimPrefix(line, "gitdir: ")
+	if !filepath.IsAbs(dir) {
+		dir = filepath.Join(filepath.Dir(path), dir)
+	}
+	return dir, nil
+}
+
+// dirs returns the directories to watch for the commit to change: the git
+// directory for HEAD and packed-refs, and the directories of branches.
+func (g *gitRepo) dirs() []string {
+	dirs := []string{g.dir}
+	if g.common != g.dir {
+		dirs = append(dirs, g.common)
+	}
+	filepath.WalkDir(filepath.Join(g.common, "refs", "heads"), func(s string, d fs.DirEntry, err error) error {
+		if err == nil && d.IsDir() {
+			dirs = append(dirs, s)
+		}
+		return nil
+	})
+	return dirs
+}
+
+// contains reports whether the file is one of the repository that may
+// change the checked out commit.
+func (g *gitRepo) contains(path string) bool {
+	dir := filepath.Dir(path)
+	return dir == g.dir || dir == g.common || within(filepath.Join(g.common, "refs", "heads"), path)
+}
+
+// head returns the branch checked out, or "" when detached, and the
+// commit.
+func (g *gitRepo) head() (ref, hash string) {
+	b, err := os.ReadFile(filepath.Join(g.dir, "HEAD"))
+	if err != nil {
+		return "", ""
+	}
+	head := strings.TrimSpace(string(b))
+	if !strings.HasPrefix(head, "ref: ") {
+		return "", head
+	}
+	ref = strings.TrimPrefix(head, "ref: ")
+	if b, err := os.ReadFile(filepath.Join(g.common, filepath.FromSlash(ref))); err == nil {
+		return ref, strings.TrimSpace(string(b))
+	}
+	return ref, g.packed(ref)
+}
+
+// packed returns the commit of the ref in the packed-refs file.
+func (g *gitRepo) packed(ref string) string {
+	f, err := os.Open(filepath.Join(g.common, "packed-refs"))
+	if err != nil {
+		return ""
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		if hash, name, ok := strings.Cut(s.Text(), " "); ok && name == ref {
+			return hash
+		}
+	}
+	return ""
+}
+
+// moved records the commit checked out now, and reports whether it
+// changed.
+func (g *gitRepo) moved() (ref, hash string, ok bool) {
+	ref, hash = g.head()
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if hash == "" || ref == g.ref && hash == g.hash {
+		return ref, hash, false
+	}
+	g.ref, g.hash = ref, hash
+	return ref, hash, true
+}
+
+// gitOf returns the watched repository the file is part of, or nil.
+func (r *Run) gitOf(path string) *gitRepo {
+	for _, g := range r.gits {
+		if g.contains(path) {
+			return g
+		}
+	}
+	return nil
+}
+
+// gitChanged restarts the command when the event moved the checked out
+// commit of the repository.
+func (r *Run) gitChanged(g *gitRepo, event fsnotify.Event) {
+	if event.Op&fsnotify.Create == fsnotify.Create && isDir(event.Name) {
+		// a branch with a slash in its name.
+		r.add(event.Name)
+		return
+	}
+	ref, hash, ok := g.moved()
+	if !ok {
+		return
+	}
+	if time.Now().Before(r.warmup) {
+		r.debugf("Ignored git change during -warmup: %s", event.Name)
+		return
+	}
+	if len(hash) > 12 {
+		hash = hash[:12]
+	}
+	name := strings.TrimPrefix(ref, "refs/heads/")
+	if name == "" {
+		name = "detached HEAD"
+	}
+	head := filepath.Join(g.dir, "HEAD")
+	r.printf(colorSuccess, "Git checkout changed: %s at %s", name, hash)
+	r.emit(Event{Type: "change", Path: head, Message: name + " " + hash})
+	r.send(trigger{reason: "git", path: head})
+}
@@ -0,0 +1,74 @@
+package f5
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+const (
+	hash1 = "1111111111111111111111111111111111111111"
+	hash2 = "2222222222222222222222222222222222222222"
+	hash3 = "3333333333333333333333333333333333333333"
+)
+
+func TestGitHead(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".git/HEAD", "ref: refs/heads/main\n")
+	writeFile(t, dir, ".git/packed-refs", "# pack-refs with: peeled\n"+hash1+" refs/heads/main\n"+hash2+" refs/heads/dev\n")
+	mkdirs(t, dir, "src")
+	g, err := findGit(filepath.Join(dir, "src"))
+	if err != nil || g == nil {
+		t.Fatalf("findGit: %v, %v", g, err)
+	}
+	if g.ref != "refs/heads/main" || g.hash != hash1 {
+		t.Errorf("head %s at %s, want main at the packed commit", g.ref, g.hash)
+	}
+	// a loose ref wins over a packed one.
+	writeFile(t, dir, ".git/refs/heads/main", hash2+"\n")
+	if ref, hash, ok := g.moved(); !ok || ref != "refs/heads/main" || hash != hash2 {
+		t.Errorf("moved() = %s, %s, %v, want main at the loose commit", ref, hash, ok)
+	}
+	if _, _, ok := g.moved(); ok {
+		t.Error("moved again without a change")
+	}
+	writeFile(t, dir, ".git/HEAD", hash3+"\n")
+	if ref, hash, ok := g.moved(); !ok || ref != "" || hash != hash3 {
+		t.Errorf("moved() = %q, %s, %v, want a detached HEAD", ref, hash, ok)
+	}
+}
+
+func TestGitWorktree(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, "repo/.git/refs/heads/main", hash1+"\n")
+	writeFile(t, dir, "repo/.git/worktrees/wt/HEAD", "ref: refs/heads/main\n")
+	writeFile(t, dir, "repo/.git/worktrees/wt/commondir", "../..\n")
+	writeFile(t, dir, "wt/.git", "gitdir: ../repo/.git/worktrees/wt\n")
+	g, err := findGit(filepath.Join(dir, "wt"))
+	if err != nil || g == nil {
+		t.Fatalf("findGit: %v, %v", g, err)
+	}
+	if want := filepath.Join(dir, "repo/.git"); g.common != want {
+		t.Errorf("common dir %s, want %s", g.common, want)
+	}
+	if g.hash != hash1 {
+		t.Errorf("head at %s, want the commit of the main worktree's ref", g.hash)
+	}
+}
+
+func TestWatchGit(t *testing.T) {
+	dir := t.TempDir()
+	head := writeFile(t, dir, ".git/HEAD", "ref: refs/heads/main\n")
+	writeFile(t, dir, ".git/refs/heads/main", hash1+"\n")
+	writeFile(t, dir, "main.go", "package main\n")
+	r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}, WatchGit: true})
+	got := watchRestarts(t, r, func() {
+		// as git commit does, writing the ref aside and renaming it.
+		writeFile(t, dir, ".git/refs/heads/main.lock", hash2+"\n")
+		os.Rename(filepath.Join(dir, ".git/refs/heads/main.lock"), filepath.Join(dir, ".git/refs/heads/main"))
+	})
+	if want := []trigger{{reason: "git", path: head}}; !reflect.DeepEqual(got, want) {
+		t.Errorf("restarts %+v, want %+v", got, want)
+	}
+}
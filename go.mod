@@ -3,9 +3,9 @@ module github.com/yukinying/f5
 go 1.18
 
 require (
+	github.com/creack/pty v1.1.18
 	github.com/fsnotify/fsnotify v1.5.4
 	github.com/pkg/term v1.1.0
 	github.com/tj/go-terminput v1.0.0
+	golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a
 )
-
-require golang.org/x/sys v0.0.0-20220520151302-bc2c85ada10a // indirect
@@ -0,0 +1,133 @@
+package f5
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"os/signal"
+	"path/filepath"
+	"strconv"
+	"strings"
+	"syscall"
+)
+
+// goStatusEnv is the environment variable naming the file f5, run by go
+// run as the helper running the program it built, writes how the program
+// exited to. go run itself exits with 1 whatever the program exited with.
+const goStatusEnv = "F5_GO_STATUS"
+
+// isGoRun reports whether the command is go run, without its own -exec.
+func isGoRun(args []string) bool {
+	if len(args) < 2 || filepath.Base(args[0]) != "go" || args[1] != "run" {
+		return false
+	}
+	for _, a := range args[2:] {
+		if !strings.HasPrefix(a, "-") {
+			break
+		}
+		if a == "-exec" || strings.HasPrefix(a, "-exec=") {
+			return false
+		}
+	}
+	return true
+}
+
+// goRunArgs returns the go run command args with self, f5, as the helper
+// running the program built. go run splits -exec as a command line of its
+// own, with quotes but no escapes, so self cannot contain both kinds of
+// quotes.
+func goRunArgs(args []string, self string) ([]string, error) {
+	exe := self
+	switch {
+	case !strings.ContainsAny(self, " \t\n'\""):
+	case !strings.Contains(self, "'"):
+		exe = "'" + self + "'"
+	case !strings.Contains(self, "\""):
+		exe = "\"" + self + "\""
+	default:
+		return nil, fmt.Errorf("cannot pass %s to go run -exec, as it contains quotes", self)
+	}
+	// -C has to be the first flag.
+	n := 2
+	if len(args) > 3 && args[2] == "-C" {
+		n = 4
+	} else if len(args) > 2 && strings.HasPrefix(args[2], "-C=") {
+		n = 3
+	}
+	run := append(append([]string(nil), args[:n]...), "-exec", exe)
+	return append(run, args[n:]...), nil
+}
+
+// goStatusFile returns the file the helper writes how the program of the
+// run exited to.
+func goStatusFile(run int) string {
+	return filepath.Join(os.TempDir(), fmt.Sprintf("f5-%d-%d.status", os.Getpid(), run))
+}
+
+// goExit is how the program built by go run exited, as reported by the
+// helper.
+type goExit struct {
+	code int
+	sig  syscall.Signal
+}
+
+func (e *goExit) Error() string {
+	if e.sig != 0 {
+		return "signal: " + e.sig.String()
+	}
+	return fmt.Sprintf("exit status %d", e.code)
+}
+
+// goResult returns the result of the run of go run, err, as how the
+// program it built exited, when the helper got to report it.
+func goResult(run int, err error) error {
+	path := goStatusFile(run)
+	b, rerr := os.ReadFile(path)
+	if rerr != nil {
+		// the program did not build, or go run was stopped first.
+		return err
+	}
+	os.Remove(path)
+	kind, value, _ := strings.Cut(strings.TrimSpace(string(b)), " ")
+	n, cerr := strconv.Atoi(value)
+	switch {
+	case cerr != nil:
+		return err
+	case kind == "signal":
+		return &goExit{sig: syscall.Signal(n)}
+	case n != 0:
+		return &goExit{code: n}
+	}
+	return err
+}
+
+// runGoProgram runs the program built by go run, writes how it exited to
+// path, and returns the exit code to exit with.
+func runGoProgram(path string, args []string) int {
+	if len(args) == 0 {
+		fmt.Fprintln(os.Stderr, "f5: no program to run")
+		return 126
+	}
+	// the signals f5 sends the process group reach the program too, and
+	// the helper waits for it to exit on them.
+	signal.Notify(make(chan os.Signal, 1), syscall.SIGINT, syscall.SIGTERM, syscall.SIGHUP, syscall.SIGQUIT)
+	cmd := exec.Command(args[0], args[1:]...)
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = os.Stdin, os.Stdout, os.Stderr
+	if err := cmd.Run(); err != nil && cmd.ProcessState == nil {
+		fmt.Fprintf(os.Stderr, "f5: %v\n", err)
+		return 126
+	}
+	status := fmt.Sprintf("exit %d", cmd.ProcessState.ExitCode())
+	ws, _ := cmd.ProcessState.Sys().(syscall.WaitStatus)
+	if ws.Signaled() {
+		status = fmt.Sprintf("signal %d", ws.Signal())
+	}
+	os.WriteFile(path, []byte(status+"\n"), 0o600)
+	if ws.Signaled() {
+		// die the same way, for go run to report it as it would.
+		signal.Reset(ws.Signal())
+		syscall.Kill(os.Getpid(), ws.Signal())
+		return 128 + int(ws.Signal())
+	}
+	return cmd.ProcessState.ExitCode()
+}
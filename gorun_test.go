@@ -0,0 +1,232 @@
+package f5
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"path/filepath"
+	"reflect"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+func TestIsGoRun(t *testing.T) {
+	tests := []struct {
+		args []string
+		want bool
+	}{
+		{[]string{"go", "run", "."}, true},
+		{[]string{"/usr/local/go/bin/go", "run", "./cmd/server", "-port", "8080"}, true},
+		{[]string{"go", "run", "-race", "-exec", "sudo", "."}, false},
+		{[]string{"go", "run", "-exec=sudo", "."}, false},
+		// -exec after the package is an argument of the program.
+		{[]string{"go", "run", ".", "-exec", "x"}, true},
+		{[]string{"go", "build", "."}, false},
+		{[]string{"go"}, false},
+		{[]string{"gofmt", "run"}, false},
+	}
+	for _, tt := range tests {
+		if got := isGoRun(tt.args); got != tt.want {
+			t.Errorf("isGoRun(%q) = %v, want %v", tt.args, got, tt.want)
+		}
+	}
+}
+
+func TestGoRunArgs(t *testing.T) {
+	tests := []struct {
+		self string
+		want []string
+		err  bool
+	}{
+		{self: "/bin/f5", want: []string{"go", "run", "-exec", "/bin/f5", "-race", ".", "-v"}},
+		{self: "/my tools/f5", want: []string{"go", "run", "-exec", "'/my tools/f5'", "-race", ".", "-v"}},
+		{self: "/bob's/f5", want: []string{"go", "run", "-exec", `"/bob's/f5"`, "-race", ".", "-v"}},
+		{self: `/bob's "tools"/f5`, err: true},
+	}
+	for _, tt := range tests {
+		got, err := goRunArgs([]string{"go", "run", "-race", ".", "-v"}, tt.self)
+		if (err != nil) != tt.err || !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("goRunArgs(%q) = %q, %v, want %q, error %v", tt.self, got, err, tt.want, tt.err)
+		}
+	}
+	// -C stays the first flag.
+	for _, args := range [][]string{
+		{"go", "run", "-C", "app", "."},
+		{"go", "run", "-C=app", "."},
+	} {
+		want := append(append([]string(nil), args[:len(args)-1]...), "-exec", "/bin/f5", ".")
+		if got, err := goRunArgs(args, "/bin/f5"); err != nil || !reflect.DeepEqual(got, want) {
+			t.Errorf("goRunArgs(%q) = %q, %v, want %q", args, got, err, want)
+		}
+	}
+}
+
+func TestGoStatusRequiresGoRun(t *testing.T) {
+	for _, args := range [][]string{{"go", "build", "."}, {"go", "run", "-exec", "sudo", "."}} {
+		if _, err := NewWithConfig(Config{GoStatus: true}, args...); err == nil {
+			t.Errorf("NewWithConfig(-go-status, %q) succeeded, want an error", args)
+		}
+	}
+}
+
+func TestGoStatusHelper(t *testing.T) {
+	tests := []struct {
+		script string
+		status string
+		want   error
+	}{
+		{script: "exit 0", status: "exit 0\n", want: nil},
+		{script: "exit 3", status: "exit 3\n", want: &goExit{code: 3}},
+		{script: "kill -TERM $$", status: "signal 15\n", want: &goExit{sig: syscall.SIGTERM}},
+	}
+	for i, tt := range tests {
+		path := filepath.Join(t.TempDir(), "status")
+		// the test binary runs as the helper, see TestMain.
+		cmd := exec.Command(os.Args[0], "sh", "-c", tt.script)
+		cmd.Env = append(os.Environ(), goStatusEnv+"="+path)
+		cmd.Run()
+		b, err := os.ReadFile(path)
+		if err != nil || string(b) != tt.status {
+			t.Errorf("%q: status %q, %v, want %q", tt.script, b, err, tt.status)
+			continue
+		}
+		// goResult reads the status file of the run.
+		run := 1000 + i
+		if err := os.WriteFile(goStatusFile(run), b, 0o600); err != nil {
+			t.Fatal(err)
+		}
+		if got := goResult(run, nil); !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("%q: goResult = %v, want %v", tt.script, got, tt.want)
+		}
+	}
+}
+
+// goProgram is a program starting a child of its own, writing both pids
+// to the file given, and then running until stopped, or exiting with the
+// code given.
+const goProgram = `package main
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"strconv"
+	"time"
+)
+
+func main() {
+	if len(os.Args) > 2 {
+		code, _ := strconv.Atoi(os.Args[2])
+		os.Exit(code)
+	}
+	child := exec.Command("sleep", "60")
+	if err := child.Start(); err != nil {
+		panic(err)
+	}
+	pids := fmt.Sprintf("%d %d\n", os.Getpid(), child.Process.Pid)
+	os.WriteFile(os.Args[1], []byte(pids), 0o644)
+	time.Sleep(time.Minute)
+}
+`
+
+// gone reports whether the process has exited, reaped or not.
+func gone(pid int) bool {
+	b, err := os.ReadFile(fmt.Sprintf("/proc/%d/stat", pid))
+	if err != nil {
+		return true
+	}
+	f := strings.Fields(string(b[strings.LastIndexByte(string(b), ')')+1:]))
+	return len(f) > 0 && f[0] == "Z"
+}
+
+func TestGoRun(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip(err)
+	}
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc")
+	}
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module tiny\n\ngo 1.18\n")
+	writeFile(t, dir, "main.go", goProgram)
+	pidFile := filepath.Join(dir, "pids")
+
+	for _, goStatus := range []bool{false, true} {
+		os.Remove(pidFile)
+		r, err := NewWithConfig(Config{Roots: []Root{{Dir: dir}}, GoStatus: goStatus}, "go", "run", "-C", dir, ".", pidFile)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Restart(context.Background())
+		var pids []int
+		for deadline := time.Now().Add(time.Minute); len(pids) < 2; time.Sleep(50 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatal("program not started")
+			}
+			b, _ := os.ReadFile(pidFile)
+			pids = nil
+			for _, f := range strings.Fields(string(b)) {
+				pid, _ := strconv.Atoi(f)
+				pids = append(pids, pid)
+			}
+		}
+		r.Close()
+		for deadline := time.Now().Add(5 * time.Second); !gone(pids[0]) || !gone(pids[1]); time.Sleep(50 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				t.Fatalf("-go-status=%v: program %d or its child %d still running after Close", goStatus, pids[0], pids[1])
+			}
+		}
+	}
+}
+
+// TestGoRunExit checks that the exit of the program, rather than that of
+// go run, is reported with -go-status.
+func TestGoRunExit(t *testing.T) {
+	if testing.Short() {
+		t.Skip("builds a program")
+	}
+	if _, err := exec.LookPath("go"); err != nil {
+		t.Skip(err)
+	}
+	dir := t.TempDir()
+	writeFile(t, dir, "go.mod", "module tiny\n\ngo 1.18\n")
+	writeFile(t, dir, "main.go", goProgram)
+	for _, tt := range []struct {
+		goStatus bool
+		want     int
+	}{
+		{false, 1},
+		{true, 3},
+	} {
+		r, err := NewWithConfig(Config{Roots: []Root{{Dir: dir}}, GoStatus: tt.goStatus}, "go", "run", "-C", dir, ".", "-", "3")
+		if err != nil {
+			t.Fatal(err)
+		}
+		events, stop := r.events.subscribe()
+		r.Restart(context.Background())
+		timeout := time.After(time.Minute)
+	wait:
+		for {
+			select {
+			case e := <-events:
+				if e.Type == "exit" {
+					if e.Code == nil || *e.Code != tt.want {
+						t.Errorf("-go-status=%v: exit %v, want %d", tt.goStatus, e.Code, tt.want)
+					}
+					break wait
+				}
+			case <-timeout:
+				t.Fatal("program did not exit")
+			}
+		}
+		stop()
+		r.Close()
+	}
+}
@@ -0,0 +1,115 @@
+package f5
+
+import (
+	"context"
+	"crypto/sha256"
+	"fmt"
+	"go/ast"
+	"go/parser"
+	"go/token"
+	"os"
+	"path/filepath"
+	"reflect"
+	"strings"
+	"sync"
+)
+
+// goSums holds a digest of the syntax tree of each Go file seen, without
+// comments and positions, for -go-semantic.
+type goSums struct {
+	mu   sync.Mutex
+	sums map[string][sha256.Size]byte
+}
+
+// update records the digest of the file, and reports whether it is the
+// same as the one recorded before. A file that does not parse is never the
+// same.
+func (g *goSums) update(path string) bool {
+	sum, err := goDigest(path)
+	g.mu.Lock()
+	defer g.mu.Unlock()
+	if g.sums == nil {
+		g.sums = map[string][sha256.Size]byte{}
+	}
+	if err != nil {
+		delete(g.sums, path)
+		return false
+	}
+	last, ok := g.sums[path]
+	g.sums[path] = sum
+	return ok && last == sum
+}
+
+// prime records the digests of the Go files in dirs, so that their first
+// change can be compared, until ctx is done.
+func (g *goSums) prime(ctx context.Context, dirs []string) {
+	for _, d := range dirs {
+		if ctx.Err() != nil {
+			return
+		}
+		files, _ := filepath.Glob(filepath.Join(d, "*.go"))
+		for _, f := range files {
+			g.update(f)
+		}
+	}
+}
+
+// goDigest returns the digest of the syntax tree of the Go file.
+func goDigest(path string) ([sha256.Size]byte, error) {
+	src, err := os.ReadFile(path)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	f, err := parser.ParseFile(token.NewFileSet(), path, src, parser.SkipObjectResolution)
+	if err != nil {
+		return [sha256.Size]byte{}, err
+	}
+	var b strings.Builder
+	dumpNode(&b, reflect.ValueOf(f))
+	return sha256.Sum256([]byte(b.String())), nil
+}
+
+var (
+	posType     = reflect.TypeOf(token.NoPos)
+	commentType = reflect.TypeOf(&ast.CommentGroup{})
+	objectType  = reflect.TypeOf(&ast.Object{})
+	scopeType   = reflect.TypeOf(&ast.Scope{})
+)
+
+// dumpNode writes the syntax tree at v, leaving out what does not change
+// what the code does: positions, comments and resolved objects.
+func dumpNode(b *strings.Builder, v reflect.Value) {
+	switch v.Kind() {
+	case reflect.Ptr, reflect.Interface:
+		if v.IsNil() {
+			b.WriteString("nil")
+			return
+		}
+		dumpNode(b, v.Elem())
+	case reflect.Struct:
+		b.WriteString(v.Type().Name() + "{")
+		for i := 0; i < v.NumField(); i++ {
+			switch t := v.Type().Field(i).Type; {
+			case t == posType, t == commentType, t == objectType, t == scopeType:
+				continue
+			case t.Kind() == reflect.Slice && t.Elem() == commentType:
+				continue
+			}
+			dumpNode(b, v.Field(i))
+			b.WriteString(";")
+		}
+		b.WriteString("}")
+	case reflect.Slice:
+		b.WriteString("[")
+		for i := 0; i < v.Len(); i++ {
+			dumpNode(b, v.Index(i))
+			b.WriteString(",")
+		}
+		b.WriteString("]")
+	case reflect.Map:
+		// only the unresolved identifiers of a file, which follow from the
+		// rest of the tree.
+	default:
+		fmt.Fprintf(b, "%v", v)
+	}
+}
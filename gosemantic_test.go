@@ -0,0 +1,82 @@
+package f5
+
+import "testing"
+
+func TestGoSums(t *testing.T) {
+	const orig = `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello")
+}
+`
+	tests := []struct {
+		name, src string
+		same      bool
+	}{
+		{"comment", `package main
+
+import "fmt"
+
+// main says hello.
+func main() {
+	fmt.Println("hello") // to stdout
+}
+`, true},
+		{"formatting", `package main
+import "fmt"
+func main() { fmt.Println("hello") }
+`, true},
+		{"string", `package main
+
+import "fmt"
+
+func main() {
+	fmt.Println("hello, world")
+}
+`, false},
+		{"call", `package main
+
+import "fmt"
+
+func main() {
+	fmt.Print("hello")
+}
+`, false},
+		{"syntax error", `package main
+
+func main() {
+`, false},
+	}
+	dir := t.TempDir()
+	for _, tt := range tests {
+		var g goSums
+		path := writeFile(t, dir, "main.go", orig)
+		if g.update(path) {
+			t.Fatal("first update reported the same")
+		}
+		writeFile(t, dir, "main.go", tt.src)
+		if got := g.update(path); got != tt.same {
+			t.Errorf("%s change: same = %v, want %v", tt.name, got, tt.same)
+		}
+	}
+}
+
+// TestGoSumsAfterError checks that a file fixed after a syntax error is
+// compared afresh.
+func TestGoSumsAfterError(t *testing.T) {
+	var g goSums
+	dir := t.TempDir()
+	path := writeFile(t, dir, "main.go", "package main\n")
+	g.update(path)
+	writeFile(t, dir, "main.go", "package main\nfunc\n")
+	g.update(path)
+	writeFile(t, dir, "main.go", "package main\n")
+	if g.update(path) {
+		t.Error("fixed file reported the same as before the error")
+	}
+	if !g.update(path) {
+		t.Error("unchanged file reported changed")
+	}
+}
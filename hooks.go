@@ -0,0 +1,94 @@
+package f5
+
+import (
+	"context"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// cleanupTimeout bounds the -cleanup command, so it cannot hang shutdown.
+const cleanupTimeout = 10 * time.Second
+
+// hookProgress is how often a hook still running is reported on the
+// terminal, so that f5 does not look stuck during a slow build.
+const hookProgress = 5 * time.Second
+
+// hook runs a shell command for the named hook, with its output going
+// where the command's does, and reports how it went.
+func (r *Run) hook(ctx context.Context, name, command string) error {
+	r.printf(colorInfo, "Running %s: %s%s", name, r.theme[colorAccent], command)
+	cmd := exec.Command("sh", "-c", command)
+	cmd.Stdout = r.stdout
+	cmd.Stderr = r.stderr
+	// in its own process group, so that stopping it when ctx is done also
+	// stops what it runs, which may hold its output open.
+	cmd.SysProcAttr = &syscall.SysProcAttr{Setpgid: true}
+	start := time.Now()
+	err := cmd.Start()
+	if err == nil {
+		done := make(chan struct{})
+		go func() {
+			var tick <-chan time.Time
+			if r.Interactive() {
+				t := time.NewTicker(hookProgress)
+				defer t.Stop()
+				tick = t.C
+			}
+			for {
+				select {
+				case <-ctx.Done():
+					syscall.Kill(-cmd.Process.Pid, syscall.SIGKILL)
+					return
+				case <-tick:
+					r.printf(colorInfo, "Still running %s (%s)", name, time.Since(start).Round(time.Second))
+				case <-done:
+					return
+				}
+			}
+		}()
+		err = cmd.Wait()
+		close(done)
+	}
+	took := time.Since(start).Round(time.Millisecond)
+	if ctx.Err() == context.DeadlineExceeded {
+		r.printf(colorError, "%s timed out after %s", name, took)
+		return ctx.Err()
+	}
+	if err != nil {
+		r.printf(colorError, "%s failed after %s: %v", name, took, err)
+		return err
+	}
+	r.printf(colorSuccess, "%s done in %s", name, took)
+	return nil
+}
+
+// onStart runs the -on-start command for the run p that just started,
+// waiting for it before going on. The run goes on when it fails.
+func (r *Run) onStart(ctx context.Context, p *proc) {
+	if r.cfg.OnStartTimeout > 0 {
+		var cancel context.CancelFunc
+		ctx, cancel = context.WithTimeout(ctx, r.cfg.OnStartTimeout)
+		defer cancel()
+	}
+	if err := r.hook(ctx, "on-start", r.cfg.OnStart); err != nil && !p.exited() {
+		r.printf(colorWarn, "Process %d keeps running", p.Pid)
+	}
+}
+
+// cleanup runs the -cleanup command once the command has exited, or
+// after it had a moment to.
+func (r *Run) cleanup(p *proc) {
+	if r.cfg.Cleanup == "" {
+		return
+	}
+	ctx, cancel := context.WithTimeout(context.Background(), cleanupTimeout)
+	defer cancel()
+	if p != nil {
+		select {
+		case <-p.done:
+		case <-time.After(time.Second):
+		}
+	}
+	r.hook(ctx, "cleanup", r.cfg.Cleanup)
+}
@@ -0,0 +1,93 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// imports is the set of directories of the packages the Go program run by
+// the command imports, for -import-aware.
+type imports struct {
+	// target are the arguments to go list naming the main package.
+	target []string
+
+	mu   sync.Mutex
+	dirs map[string]bool
+}
+
+// goTarget returns the package run or built by a go run or go build
+// command, as arguments to go list, or nil for another command.
+func goTarget(args []string) []string {
+	if len(args) < 2 || filepath.Base(args[0]) != "go" || (args[1] != "run" && args[1] != "build") {
+		return nil
+	}
+	target := []string{}
+	for i := 2; i < len(args); i++ {
+		a := args[i]
+		if a == "--" {
+			break
+		}
+		if strings.HasPrefix(a, "-") {
+			// flags taking a value as the next argument.
+			if !strings.Contains(a, "=") && (a == "-o" || a == "-tags" || a == "-ldflags" || a == "-gcflags" || a == "-exec" || a == "-mod" || a == "-C") {
+				i++
+			}
+			continue
+		}
+		if strings.HasSuffix(a, ".go") {
+			target = append(target, a)
+			continue
+		}
+		if len(target) == 0 {
+			target = append(target, a)
+		}
+		// the rest are the arguments of the program.
+		if args[1] == "run" {
+			break
+		}
+	}
+	if len(target) == 0 {
+		target = []string{"."}
+	}
+	return target
+}
+
+// update lists the packages imported again, and reports whether it could.
+// When it cannot, every Go file counts as imported.
+func (im *imports) update(ctx context.Context) error {
+	args := append([]string{"list", "-deps", "-f", "{{if not .Standard}}{{.Dir}}{{end}}"}, im.target...)
+	out, err := exec.CommandContext(ctx, "go", args...).Output()
+	var dirs map[string]bool
+	if err == nil {
+		dirs = map[string]bool{}
+		for _, d := range bytes.Fields(out) {
+			dirs[string(d)] = true
+		}
+	}
+	im.mu.Lock()
+	im.dirs = dirs
+	im.mu.Unlock()
+	return err
+}
+
+// imported reports whether the Go file is in a package imported by the
+// program, or the packages are not known.
+func (im *imports) imported(path string) bool {
+	im.mu.Lock()
+	defer im.mu.Unlock()
+	return im.dirs == nil || im.dirs[filepath.Dir(path)]
+}
+
+// updateImports lists the packages imported by the program again, after it
+// was started, as its imports may have changed.
+func (r *Run) updateImports(ctx context.Context) {
+	if err := r.imports.update(ctx); err != nil && ctx.Err() == nil {
+		r.printf(colorWarn, "Cannot list the packages imported, restarting for any Go file: %v", err)
+		return
+	}
+	r.debugf("listed the packages imported by %s", strings.Join(r.imports.target, " "))
+}
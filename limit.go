@@ -0,0 +1,128 @@
+package f5
+
+import (
+	"fmt"
+	"os"
+	"os/exec"
+	"sort"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// limitEnv is the environment variable passing the resource limits to
+// f5 run as the helper setting them, as they can only be set for the
+// command between fork and exec.
+const limitEnv = "F5_RLIMITS"
+
+// limitResources are the resources that can be limited, and whether their
+// limit is a size in bytes.
+var limitResources = map[string]struct {
+	resource int
+	size     bool
+}{
+	"as":     {unix.RLIMIT_AS, true},
+	"core":   {unix.RLIMIT_CORE, true},
+	"cpu":    {unix.RLIMIT_CPU, false},
+	"data":   {unix.RLIMIT_DATA, true},
+	"fsize":  {unix.RLIMIT_FSIZE, true},
+	"nofile": {unix.RLIMIT_NOFILE, false},
+	"nproc":  {unix.RLIMIT_NPROC, false},
+	"stack":  {unix.RLIMIT_STACK, true},
+}
+
+var sizeUnits = map[string]uint64{"": 1, "K": 1 << 10, "M": 1 << 20, "G": 1 << 30}
+
+// parseLimits checks the limits, from resource name to value, and returns
+// them as the value of limitEnv, or "" when there are none.
+func parseLimits(limits map[string]string) (string, error) {
+	pairs := []string{}
+	for name, value := range limits {
+		res, ok := limitResources[name]
+		if !ok {
+			names := []string{}
+			for n := range limitResources {
+				names = append(names, n)
+			}
+			sort.Strings(names)
+			return "", fmt.Errorf("unknown resource %q, expect one of %s", name, strings.Join(names, ", "))
+		}
+		n, err := parseLimit(value, res.size)
+		if err != nil {
+			return "", fmt.Errorf("limit %s: %v", name, err)
+		}
+		pairs = append(pairs, fmt.Sprintf("%s=%d", name, n))
+	}
+	sort.Strings(pairs)
+	return strings.Join(pairs, ","), nil
+}
+
+// parseLimit parses a limit, which for a size may have a K, M or G suffix,
+// optionally followed by B.
+func parseLimit(value string, size bool) (uint64, error) {
+	if value == "unlimited" {
+		return unix.RLIM_INFINITY, nil
+	}
+	num, unit := value, ""
+	if size {
+		num = strings.TrimSuffix(strings.ToUpper(value), "B")
+		if i := strings.LastIndexAny(num, "0123456789"); i >= 0 {
+			num, unit = num[:i+1], num[i+1:]
+		}
+	}
+	mult, ok := sizeUnits[unit]
+	n, err := strconv.ParseUint(num, 10, 64)
+	if !ok || err != nil {
+		return 0, fmt.Errorf("bad value %q", value)
+	}
+	return n * mult, nil
+}
+
+// RunHelper runs the process as the helper f5 starts the command through,
+// if it was started as one, and does not return then. f5 runs its own
+// executable as the helper to set the resource limits of Config.Limits
+// between fork and exec, and to run the program built by go run with
+// Config.GoStatus, so a program embedding a Run with either set must call
+// RunHelper first in main.
+func RunHelper() {
+	if limits, ok := os.LookupEnv(limitEnv); ok {
+		// go run, run with the limits, may run f5 again as its -exec.
+		os.Unsetenv(limitEnv)
+		if err := runLimited(limits, os.Args[1:]); err != nil {
+			fmt.Fprintf(os.Stderr, "f5: %v\n", err)
+			os.Exit(126)
+		}
+	}
+	if path, ok := os.LookupEnv(goStatusEnv); ok {
+		os.Unsetenv(goStatusEnv)
+		os.Exit(runGoProgram(path, os.Args[1:]))
+	}
+}
+
+// runLimited sets the limits and replaces the process with the command.
+func runLimited(limits string, args []string) error {
+	for _, p := range strings.Split(limits, ",") {
+		name, value, _ := strings.Cut(p, "=")
+		n, err := strconv.ParseUint(value, 10, 64)
+		if err != nil {
+			return fmt.Errorf("bad limit %q", p)
+		}
+		// the hard limit too, so the command cannot raise it back. The
+		// syscall package keeps the limit on the number of files for the
+		// exec rather than restoring the one f5 started with.
+		lim := syscall.Rlimit{Cur: n, Max: n}
+		if err := syscall.Setrlimit(limitResources[name].resource, &lim); err != nil {
+			return fmt.Errorf("cannot limit %s to %d: %v", name, n, err)
+		}
+	}
+	if len(args) == 0 {
+		return fmt.Errorf("no command to run")
+	}
+	path, err := exec.LookPath(args[0])
+	if err != nil {
+		return err
+	}
+	return syscall.Exec(path, args, os.Environ())
+}
@@ -0,0 +1,62 @@
+package f5
+
+import (
+	"os"
+	"os/exec"
+	"strings"
+	"testing"
+
+	"golang.org/x/sys/unix"
+)
+
+func TestParseLimits(t *testing.T) {
+	tests := []struct {
+		limits map[string]string
+		want   string
+		err    bool
+	}{
+		{limits: nil, want: ""},
+		{limits: map[string]string{"nofile": "64"}, want: "nofile=64"},
+		{limits: map[string]string{"as": "512MB", "cpu": "10"}, want: "as=536870912,cpu=10"},
+		{limits: map[string]string{"stack": "8k"}, want: "stack=8192"},
+		{limits: map[string]string{"core": "unlimited"}, want: "core=18446744073709551615"},
+		{limits: map[string]string{"cpu": "10s"}, err: true},
+		{limits: map[string]string{"nofile": "1K"}, err: true},
+		{limits: map[string]string{"files": "64"}, err: true},
+	}
+	for _, tt := range tests {
+		got, err := parseLimits(tt.limits)
+		if (err != nil) != tt.err || got != tt.want {
+			t.Errorf("parseLimits(%v) = %q, %v, want %q, error %v", tt.limits, got, err, tt.want, tt.err)
+		}
+	}
+}
+
+func TestLimitsApplied(t *testing.T) {
+	var lim unix.Rlimit
+	if err := unix.Getrlimit(unix.RLIMIT_NOFILE, &lim); err != nil || lim.Max < 64 {
+		t.Skip("cannot lower the limit on open files")
+	}
+	// the test binary runs as the helper, see TestMain.
+	cmd := exec.Command(os.Args[0], "sh", "-c", "ulimit -n; ulimit -Hn")
+	cmd.Env = append(os.Environ(), limitEnv+"=nofile=64")
+	out, err := cmd.Output()
+	if err != nil {
+		t.Fatalf("helper failed: %v", err)
+	}
+	if got := strings.Fields(string(out)); len(got) != 2 || got[0] != "64" || got[1] != "64" {
+		t.Errorf("soft and hard limits on open files are %q, want 64 and 64", out)
+	}
+}
+
+func TestLimitsBad(t *testing.T) {
+	cmd := exec.Command(os.Args[0], "true")
+	cmd.Env = append(os.Environ(), limitEnv+"=nofile=many")
+	out, err := cmd.CombinedOutput()
+	if code := cmd.ProcessState.ExitCode(); err == nil || code != 126 {
+		t.Errorf("helper exited with %d, want 126", code)
+	}
+	if !strings.Contains(string(out), "bad limit") {
+		t.Errorf("helper output %q, want a bad limit error", out)
+	}
+}
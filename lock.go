@@ -0,0 +1,44 @@
+package f5
+
+import (
+	"context"
+	"os"
+	"time"
+)
+
+// lockPoll is how often the lock file is checked for while it exists.
+const lockPoll = 100 * time.Millisecond
+
+// waitLock waits for the -lock-file to be removed, for at most
+// -lock-timeout, so that the restarts queued meanwhile restart once, after
+// the tool holding it is done.
+func (r *Run) waitLock(ctx context.Context) {
+	if r.cfg.LockFile == "" {
+		return
+	}
+	if _, err := os.Stat(r.cfg.LockFile); err != nil {
+		return
+	}
+	r.printf(colorWarn, "Waiting for %s to be removed before restarting", r.cfg.LockFile)
+	var timeout <-chan time.Time
+	if r.cfg.LockTimeout > 0 {
+		t := time.NewTimer(r.cfg.LockTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	tick := time.NewTicker(lockPoll)
+	defer tick.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-timeout:
+			r.printf(colorWarn, "%s still exists after %s, restarting anyway", r.cfg.LockFile, r.cfg.LockTimeout)
+			return
+		case <-tick.C:
+		}
+		if _, err := os.Stat(r.cfg.LockFile); err != nil {
+			return
+		}
+	}
+}
@@ -0,0 +1,177 @@
+package f5
+
+import (
+	"bufio"
+	"os"
+	"path"
+	"strings"
+	"sync"
+)
+
+// pattern is a gitignore style glob. A pattern without a slash matches
+// the name of a file or directory at any depth; a pattern with a slash is
+// matched against the whole path relative to the root, and "**" matches
+// any number of directories. A trailing slash matches directories only.
+type pattern struct {
+	glob     []string
+	anchored bool
+	dirOnly  bool
+}
+
+func parsePattern(s string) pattern {
+	var p pattern
+	if strings.HasSuffix(s, "/") {
+		p.dirOnly = true
+		s = strings.TrimRight(s, "/")
+	}
+	if strings.Contains(s, "/") {
+		p.anchored = true
+		s = strings.TrimPrefix(s, "/")
+	}
+	p.glob = strings.Split(s, "/")
+	return p
+}
+
+// match reports whether the slash separated path relative to the root
+// matches the pattern.
+func (p pattern) match(rel string, isDir bool) bool {
+	if p.dirOnly && !isDir {
+		return false
+	}
+	if !p.anchored {
+		ok, _ := path.Match(p.glob[0], path.Base(rel))
+		return ok
+	}
+	return matchSegments(p.glob, strings.Split(rel, "/"))
+}
+
+func matchSegments(glob, segs []string) bool {
+	if len(glob) == 0 {
+		return len(segs) == 0
+	}
+	if glob[0] == "**" {
+		for i := 0; i <= len(segs); i++ {
+			if matchSegments(glob[1:], segs[i:]) {
+				return true
+			}
+		}
+		return false
+	}
+	if len(segs) == 0 {
+		return false
+	}
+	if ok, _ := path.Match(glob[0], segs[0]); !ok {
+		return false
+	}
+	return matchSegments(glob[1:], segs[1:])
+}
+
+// rule is a pattern that includes or excludes what it matches.
+type rule struct {
+	pattern
+	include bool
+	// base is the directory the pattern is relative to, for the patterns
+	// of a nested ignore file, which match only inside it.
+	base string
+}
+
+// matcher is an ordered list of rules, where the last matching rule wins,
+// followed by final rules, which win over the others, even those added
+// later. Rules may be added while it is in use.
+type matcher struct {
+	mu    sync.RWMutex
+	rules []rule
+	final []rule
+	// includes is set when any rule includes files, in which case only
+	// included files are watched.
+	includes bool
+}
+
+// addFinal appends patterns that include or exclude what they match, and
+// take precedence over the other rules, as those of the command line do
+// over those of the files read while watching. A "!" prefix inverts a
+// pattern.
+func (m *matcher) addFinal(include bool, patterns ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.final = m.parse(m.final, "", include, patterns)
+}
+
+// addAt appends patterns relative to the base directory, as addFinal but
+// before the final rules.
+func (m *matcher) addAt(base string, include bool, patterns ...string) {
+	m.mu.Lock()
+	defer m.mu.Unlock()
+	m.rules = m.parse(m.rules, base, include, patterns)
+}
+
+// parse appends the rules of the patterns to rules. m.mu must be held.
+func (m *matcher) parse(rules []rule, base string, include bool, patterns []string) []rule {
+	for _, s := range patterns {
+		s = strings.TrimSpace(s)
+		if s == "" || strings.HasPrefix(s, "#") {
+			continue
+		}
+		in := include
+		if strings.HasPrefix(s, "!") {
+			in = !in
+			s = s[1:]
+		}
+		rules = append(rules, rule{parsePattern(s), in, base})
+		// re-including what is ignored does not limit watching to the
+		// included files.
+		if include && in {
+			m.includes = true
+		}
+	}
+	return rules
+}
+
+// addFile appends the patterns of a gitignore style file, one per line,
+// with "#" starting a comment, relative to the base directory. A missing
+// file is not an error.
+func (m *matcher) addFile(name, base string, include bool) error {
+	f, err := os.Open(name)
+	if os.IsNotExist(err) {
+		return nil
+	}
+	if err != nil {
+		return err
+	}
+	defer f.Close()
+	s := bufio.NewScanner(f)
+	for s.Scan() {
+		m.addAt(base, include, s.Text())
+	}
+	return s.Err()
+}
+
+// decide returns whether the last final rule matching rel, or else the
+// last rule, includes it, and whether any rule matched at all.
+func (m *matcher) decide(rel string, isDir bool) (include, matched bool) {
+	m.mu.RLock()
+	defer m.mu.RUnlock()
+	if include, matched := lastMatch(m.final, rel, isDir); matched {
+		return include, true
+	}
+	return lastMatch(m.rules, rel, isDir)
+}
+
+// lastMatch returns whether the last of the rules matching rel includes
+// it, and whether any matched.
+func lastMatch(rules []rule, rel string, isDir bool) (include, matched bool) {
+	for i := len(rules) - 1; i >= 0; i-- {
+		r := rules[i]
+		p := rel
+		if r.base != "" {
+			if !strings.HasPrefix(rel, r.base+"/") {
+				continue
+			}
+			p = rel[len(r.base)+1:]
+		}
+		if r.match(p, isDir) {
+			return r.include, true
+		}
+	}
+	return false, false
+}
@@ -0,0 +1,120 @@
+package f5
+
+import (
+	"path/filepath"
+	"testing"
+)
+
+func TestMatcherFinalRulesWin(t *testing.T) {
+	var m matcher
+	m.addFinal(false, "gen", "*.pb.go")
+	// read from ignore files after the command line, at the root and in
+	// a nested directory.
+	m.addAt("", false, "!gen", "!*.pb.go")
+	m.addAt("sub", false, "!gen")
+	tests := []struct {
+		rel   string
+		isDir bool
+	}{
+		{"gen", true},
+		{"sub/gen", true},
+		{"api/api.pb.go", false},
+	}
+	for _, tt := range tests {
+		if include, matched := m.decide(tt.rel, tt.isDir); include || !matched {
+			t.Errorf("decide(%s) = %v, %v, want ignored by the final rules", tt.rel, include, matched)
+		}
+	}
+	// the other rules still decide what the final ones do not match.
+	if include, matched := m.decide("sub/other", true); matched {
+		t.Errorf("decide(sub/other) = %v, %v, want no match", include, matched)
+	}
+}
+
+func TestMatcherDecide(t *testing.T) {
+	var m matcher
+	m.addAt("", true, "# comment", "", "*.go", "!*_test.go", "/cmd/*.sh", "docs/**/*.md", "build/")
+	m.addAt("", false, "vendor", "!vendor/keep.go")
+	tests := []struct {
+		rel     string
+		isDir   bool
+		include bool
+		matched bool
+	}{
+		{"main.go", false, true, true},
+		{"pkg/a/b.go", false, true, true},
+		// negated, the later rule wins.
+		{"main_test.go", false, false, true},
+		{"pkg/a/b_test.go", false, false, true},
+		// anchored to the root.
+		{"cmd/run.sh", false, true, true},
+		{"tools/cmd/run.sh", false, false, false},
+		{"docs/a.md", false, true, true},
+		{"docs/a/b/c.md", false, true, true},
+		{"a/docs/b.md", false, false, false},
+		// directories only.
+		{"build", true, true, true},
+		{"build", false, false, false},
+		// the ignore rules, added last, win over the include ones.
+		{"vendor", true, false, true},
+		{"vendor/keep.go", false, true, true},
+		{"README", false, false, false},
+		{"# comment", false, false, false},
+	}
+	for _, tt := range tests {
+		include, matched := m.decide(tt.rel, tt.isDir)
+		if include != tt.include || matched != tt.matched {
+			t.Errorf("decide(%s, %v) = %v, %v, want %v, %v", tt.rel, tt.isDir, include, matched, tt.include, tt.matched)
+		}
+	}
+}
+
+func TestWatchFileSupported(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, watchFile, "# the served files\nweb/\n*.tmpl\n!web/*.min.js\n")
+	tests := []struct {
+		name string
+		cfg  Config
+		want map[string]bool
+	}{
+		{
+			name: "watch file",
+			want: map[string]bool{
+				"web/app.js":     true,
+				"web/app.min.js": false,
+				"web/a/b.css":    true,
+				"page.tmpl":      true,
+				// only the included files are watched.
+				"main.go": false,
+			},
+		},
+		{
+			name: "command line",
+			cfg:  Config{Include: []string{"*.go"}, Ignore: []string{"*.tmpl"}},
+			want: map[string]bool{
+				"web/app.js": true,
+				"page.tmpl":  false,
+				"main.go":    true,
+			},
+		},
+		{
+			name: "all",
+			cfg:  Config{All: true},
+			want: map[string]bool{
+				"web/app.min.js": true,
+				"main.go":        true,
+			},
+		},
+	}
+	for _, tt := range tests {
+		rt, err := newRoot(Root{Dir: dir}, tt.cfg)
+		if err != nil {
+			t.Fatal(err)
+		}
+		for rel, want := range tt.want {
+			if got := rt.supported(filepath.Join(dir, rel)); got != want {
+				t.Errorf("%s: supported(%s) = %v, want %v", tt.name, rel, got, want)
+			}
+		}
+	}
+}
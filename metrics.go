@@ -0,0 +1,55 @@
+package f5
+
+import (
+	"context"
+	"fmt"
+	"net"
+	"net/http"
+)
+
+// serveHTTP serves the HTTP endpoints on the -listen address until ctx is
+// done: /metrics with -metrics.
+func (r *Run) serveHTTP(ctx context.Context) error {
+	if r.cfg.Listen == "" {
+		return nil
+	}
+	l, err := net.Listen("tcp", r.cfg.Listen)
+	if err != nil {
+		return err
+	}
+	mux := http.NewServeMux()
+	if r.cfg.Metrics {
+		mux.HandleFunc("/metrics", r.metrics)
+		r.usagef(colorInfo, "Serving metrics on http://%s/metrics", l.Addr())
+	}
+	srv := &http.Server{Handler: mux}
+	go func() {
+		<-ctx.Done()
+		srv.Close()
+	}()
+	r.goroutine(func() { srv.Serve(l) })
+	return nil
+}
+
+// metrics writes the counters of the runner in the Prometheus text format.
+func (r *Run) metrics(w http.ResponseWriter, req *http.Request) {
+	s := r.State()
+	restarts, crashes := s.Runs-1, s.Crashes
+	uptime := 0.0
+	if s.Status == "running" {
+		uptime = s.Uptime.Seconds()
+	}
+	if restarts < 0 {
+		restarts = 0
+	}
+	dirs := len(r.watcher.WatchList()) + len(r.poller.list())
+	w.Header().Set("Content-Type", "text/plain; version=0.0.4")
+	metric(w, "f5_restarts_total", "counter", "Restarts of the command.", float64(restarts))
+	metric(w, "f5_crashes_total", "counter", "Runs of the command that exited with an error on their own.", float64(crashes))
+	metric(w, "f5_current_uptime_seconds", "gauge", "How long the command has been running, or 0.", uptime)
+	metric(w, "f5_watched_directories", "gauge", "Directories watched or polled.", float64(dirs))
+}
+
+func metric(w http.ResponseWriter, name, kind, help string, value float64) {
+	fmt.Fprintf(w, "# HELP %s %s\n# TYPE %s %s\n%s %g\n", name, help, name, kind, name, value)
+}
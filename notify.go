@@ -0,0 +1,146 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"encoding/json"
+	"fmt"
+	"io"
+	"net/http"
+	"os"
+	"os/exec"
+	"runtime"
+	"time"
+)
+
+// Notifier is told about the runs of the command, for Config.Notifiers.
+// Its methods are called one at a time, in the order of the events, so a
+// slow notifier delays the next ones but not the runner.
+type Notifier interface {
+	// OnRestart is called with the "start" event of each run.
+	OnRestart(e Event)
+	// OnExit is called with the "exit" event of a run that exited on its
+	// own, rather than being stopped by f5.
+	OnExit(e Event)
+	// OnError is called with the "error" event of a run that could not
+	// be started or built.
+	OnError(e Event)
+}
+
+// notify passes the events to the notifiers until ctx is done.
+func (r *Run) notify(ctx context.Context, events <-chan Event, stop func()) {
+	defer stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case e := <-events:
+			for _, n := range r.cfg.Notifiers {
+				switch e.Type {
+				case "start":
+					n.OnRestart(e)
+				case "exit":
+					n.OnExit(e)
+				case "error":
+					n.OnError(e)
+				}
+			}
+		}
+	}
+}
+
+// failure returns the message about an "exit" event of a run that failed,
+// or "" if it exited with 0.
+func failure(e Event) string {
+	if e.Code != nil && *e.Code == 0 {
+		return ""
+	}
+	status := "was terminated"
+	if e.Code != nil && *e.Code > 0 {
+		status = fmt.Sprintf("exited with %d", *e.Code)
+	}
+	return fmt.Sprintf("Process %d %s", e.PID, status)
+}
+
+// StderrNotifier returns a Notifier writing a line to standard error when
+// the command fails or cannot be started, ringing the terminal bell, for
+// programs sending the output of f5 elsewhere. f5 reports failures in its
+// own output already.
+func StderrNotifier() Notifier {
+	return stderrNotifier{w: os.Stderr}
+}
+
+type stderrNotifier struct {
+	w io.Writer
+}
+
+func (stderrNotifier) OnRestart(e Event) {}
+
+func (n stderrNotifier) OnExit(e Event) {
+	if msg := failure(e); msg != "" {
+		fmt.Fprintf(n.w, "\af5: %s\n", msg)
+	}
+}
+
+func (n stderrNotifier) OnError(e Event) {
+	fmt.Fprintf(n.w, "\af5: %s\n", e.Message)
+}
+
+// DesktopNotifier returns a Notifier showing a desktop notification when
+// the command fails or cannot be started, with notify-send, or osascript
+// on macOS.
+func DesktopNotifier() Notifier {
+	return desktop{}
+}
+
+type desktop struct{}
+
+func (desktop) OnRestart(e Event) {}
+
+func (desktop) OnExit(e Event) {
+	if msg := failure(e); msg != "" {
+		desktopNotify(msg)
+	}
+}
+
+func (desktop) OnError(e Event) {
+	desktopNotify(e.Message)
+}
+
+func desktopNotify(message string) {
+	cmd := exec.Command("notify-send", "f5", message)
+	if runtime.GOOS == "darwin" {
+		cmd = exec.Command("osascript", "-e", fmt.Sprintf("display notification %q with title \"f5\"", message))
+	}
+	cmd.Run()
+}
+
+// webhookTimeout bounds a webhook request, so a slow endpoint does not
+// hold back the next events for long.
+const webhookTimeout = 5 * time.Second
+
+// WebhookNotifier returns a Notifier posting each event as JSON to url.
+func WebhookNotifier(url string) Notifier {
+	return webhook{url: url, client: &http.Client{Timeout: webhookTimeout}}
+}
+
+type webhook struct {
+	url    string
+	client *http.Client
+}
+
+func (w webhook) OnRestart(e Event) { w.post(e) }
+func (w webhook) OnExit(e Event)    { w.post(e) }
+func (w webhook) OnError(e Event)   { w.post(e) }
+
+func (w webhook) post(e Event) {
+	b, err := json.Marshal(e)
+	if err != nil {
+		return
+	}
+	resp, err := w.client.Post(w.url, "application/json", bytes.NewReader(b))
+	if err != nil {
+		return
+	}
+	resp.Body.Close()
+}
@@ -0,0 +1,23 @@
+package f5
+
+import (
+	"bytes"
+	"testing"
+)
+
+func TestStderrNotifier(t *testing.T) {
+	var out bytes.Buffer
+	n := stderrNotifier{w: &out}
+	code := func(c int) *int { return &c }
+	n.OnRestart(Event{Type: "start", PID: 10})
+	n.OnExit(Event{Type: "exit", PID: 10, Code: code(0)})
+	n.OnExit(Event{Type: "exit", PID: 11, Code: code(2)})
+	n.OnExit(Event{Type: "exit", PID: 12, Code: code(-1)})
+	n.OnError(Event{Type: "error", Message: "build failed: exit status 1"})
+	want := "\af5: Process 11 exited with 2\n" +
+		"\af5: Process 12 was terminated\n" +
+		"\af5: build failed: exit status 1\n"
+	if out.String() != want {
+		t.Errorf("notified %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,39 @@
+package f5
+
+import (
+	"fmt"
+	"strings"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// opNames maps the names given to -ops to file system operations.
+var opNames = map[string]fsnotify.Op{
+	"write":  fsnotify.Write,
+	"create": fsnotify.Create,
+	"remove": fsnotify.Remove,
+	"rename": fsnotify.Rename,
+	"chmod":  fsnotify.Chmod,
+	"all":    fsnotify.Write | fsnotify.Create | fsnotify.Remove | fsnotify.Rename | fsnotify.Chmod,
+}
+
+// parseOps returns the operations on watched files that restart the
+// command: those named in names, or writes when there are none, and
+// deletions with -restart-on-delete.
+func parseOps(names []string, onDelete bool) (fsnotify.Op, error) {
+	if len(names) == 0 {
+		names = []string{"write"}
+	}
+	var ops fsnotify.Op
+	if onDelete {
+		ops = fsnotify.Remove | fsnotify.Rename
+	}
+	for _, name := range names {
+		op, ok := opNames[strings.ToLower(strings.TrimSpace(name))]
+		if !ok {
+			return 0, fmt.Errorf("unknown -ops operation %q, expect write, create, remove, rename, chmod or all", name)
+		}
+		ops |= op
+	}
+	return ops, nil
+}
@@ -0,0 +1,71 @@
+package f5
+
+import (
+	"path/filepath"
+	"testing"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+func TestParseOps(t *testing.T) {
+	tests := []struct {
+		names    []string
+		onDelete bool
+		want     fsnotify.Op
+	}{
+		{nil, false, fsnotify.Write},
+		{nil, true, fsnotify.Write | fsnotify.Remove | fsnotify.Rename},
+		{[]string{"create"}, false, fsnotify.Create},
+		{[]string{"write", " Create ", "RENAME"}, false, fsnotify.Write | fsnotify.Create | fsnotify.Rename},
+		{[]string{"chmod"}, true, fsnotify.Chmod | fsnotify.Remove | fsnotify.Rename},
+		{[]string{"all"}, false, fsnotify.Write | fsnotify.Create | fsnotify.Remove | fsnotify.Rename | fsnotify.Chmod},
+	}
+	for _, tt := range tests {
+		got, err := parseOps(tt.names, tt.onDelete)
+		if err != nil {
+			t.Errorf("parseOps(%q, %v): %v", tt.names, tt.onDelete, err)
+			continue
+		}
+		if got != tt.want {
+			t.Errorf("parseOps(%q, %v) = %v, want %v", tt.names, tt.onDelete, got, tt.want)
+		}
+	}
+	if _, err := parseOps([]string{"write", "touch"}, false); err == nil {
+		t.Error("parseOps(touch) succeeded, want an error")
+	}
+}
+
+func TestOpsFilterEvents(t *testing.T) {
+	dir := t.TempDir()
+	// removed and renamed files are gone by the time of the event.
+	file := writeFile(t, dir, "main.go", "package main\n")
+	gone := filepath.Join(dir, "gone.go")
+	events := []fsnotify.Event{
+		{Name: file, Op: fsnotify.Write},
+		{Name: file, Op: fsnotify.Create},
+		{Name: gone, Op: fsnotify.Remove},
+		{Name: gone, Op: fsnotify.Rename},
+		{Name: file, Op: fsnotify.Chmod},
+	}
+	tests := []struct {
+		ops  []string
+		want []bool
+	}{
+		{nil, []bool{true, false, false, false, false}},
+		{[]string{"write"}, []bool{true, false, false, false, false}},
+		{[]string{"create"}, []bool{false, true, false, false, false}},
+		{[]string{"remove"}, []bool{false, false, true, false, false}},
+		{[]string{"rename"}, []bool{false, false, false, true, false}},
+		{[]string{"chmod"}, []bool{false, false, false, false, true}},
+		{[]string{"write", "create", "rename"}, []bool{true, true, false, true, false}},
+		{[]string{"all"}, []bool{true, true, true, true, true}},
+	}
+	for _, tt := range tests {
+		r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}, Ops: tt.ops})
+		for i, e := range events {
+			if got := r.reject(e) == ""; got != tt.want[i] {
+				t.Errorf("-ops %q: %v restarts = %v, want %v", tt.ops, e.Op, got, tt.want[i])
+			}
+		}
+	}
+}
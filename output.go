@@ -0,0 +1,223 @@
+package f5
+
+import (
+	"bytes"
+	"fmt"
+	"io"
+	"strings"
+	"sync"
+	"time"
+)
+
+// lineFlushInterval is how long a partial line is held by a lineWriter
+// before it is written anyway.
+const lineFlushInterval = 100 * time.Millisecond
+
+// lineWriter passes output on in whole lines, and flushes a pending
+// partial line after lineFlushInterval, so a burst of output arrives
+// promptly but is not split in the middle of a line.
+type lineWriter struct {
+	w io.Writer
+	// max, if set, is the longest partial line held, in bytes.
+	max int
+
+	mu    sync.Mutex
+	buf   []byte
+	timer *time.Timer
+}
+
+func newLineWriter(w io.Writer, max int) *lineWriter {
+	return &lineWriter{w: w, max: max}
+}
+
+func (l *lineWriter) Write(p []byte) (int, error) {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.buf = append(l.buf, p...)
+	if i := bytes.LastIndexByte(l.buf, '\n'); i >= 0 {
+		if _, err := l.w.Write(l.buf[:i+1]); err != nil {
+			return 0, err
+		}
+		l.buf = append(l.buf[:0], l.buf[i+1:]...)
+	}
+	if l.max > 0 && len(l.buf) > l.max {
+		if _, err := l.w.Write(l.buf); err != nil {
+			return 0, err
+		}
+		l.buf = l.buf[:0]
+	}
+	if len(l.buf) > 0 && l.timer == nil {
+		l.timer = time.AfterFunc(lineFlushInterval, l.flush)
+	}
+	return len(p), nil
+}
+
+// flush writes out the pending partial line.
+func (l *lineWriter) flush() {
+	l.mu.Lock()
+	defer l.mu.Unlock()
+	l.timer = nil
+	if len(l.buf) > 0 {
+		l.w.Write(l.buf)
+		l.buf = l.buf[:0]
+	}
+}
+
+// lineTap passes output on, and calls fn with each line of it. A line
+// longer than max bytes, if set, is split.
+type lineTap struct {
+	w   io.Writer
+	max int
+	fn  func(line string)
+
+	mu      sync.Mutex
+	partial []byte
+}
+
+func newLineTap(w io.Writer, max int, fn func(line string)) *lineTap {
+	return &lineTap{w: w, max: max, fn: fn}
+}
+
+func (t *lineTap) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		t.fn(strings.TrimSuffix(string(t.partial[:i]), "\r"))
+		t.partial = t.partial[i+1:]
+	}
+	if t.max > 0 && len(t.partial) > t.max {
+		t.fn(string(t.partial))
+		t.partial = nil
+	}
+	t.partial = append([]byte(nil), t.partial...)
+	t.mu.Unlock()
+	return t.w.Write(p)
+}
+
+// throttle limits the output of the command to max lines a second, across
+// its output and error output, dropping the rest with a notice.
+type throttle struct {
+	max int
+
+	mu      sync.Mutex
+	window  time.Time
+	lines   int
+	dropped int
+	// notice receives the count of lines dropped, at the end of the
+	// window they were dropped in.
+	notice io.Writer
+}
+
+func newThrottle(max int) *throttle {
+	return &throttle{max: max}
+}
+
+// writer returns a writer passing output on to w within the limit.
+func (t *throttle) writer(w io.Writer) io.Writer {
+	return throttleWriter{t: t, w: w}
+}
+
+// flush writes the notice of the lines dropped, if any. t.mu must be held.
+func (t *throttle) flush() {
+	if t.dropped > 0 {
+		fmt.Fprintf(t.notice, "[%d lines dropped]\n", t.dropped)
+		t.dropped = 0
+	}
+}
+
+type throttleWriter struct {
+	t *throttle
+	w io.Writer
+}
+
+func (x throttleWriter) Write(p []byte) (int, error) {
+	t := x.t
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if now := time.Now(); now.Sub(t.window) >= time.Second {
+		t.flush()
+		t.window, t.lines = now, 0
+	}
+	n := len(p)
+	for len(p) > 0 {
+		// a partial line counts as a line too, so that output without
+		// newlines is limited as well.
+		line := p
+		if i := bytes.IndexByte(p, '\n'); i >= 0 {
+			line = p[:i+1]
+		}
+		p = p[len(line):]
+		if t.lines < t.max {
+			t.lines++
+			if _, err := x.w.Write(line); err != nil {
+				return 0, err
+			}
+			continue
+		}
+		t.dropped++
+		if t.dropped == 1 {
+			fmt.Fprintf(x.w, "[output throttled]\n")
+			t.notice = x.w
+			t.flushLater()
+		}
+	}
+	return n, nil
+}
+
+// flushLater writes the notice of the lines dropped at the end of the
+// current window, even if no output follows. t.mu must be held.
+func (t *throttle) flushLater() {
+	window := t.window
+	time.AfterFunc(time.Until(window.Add(time.Second)), func() {
+		t.mu.Lock()
+		defer t.mu.Unlock()
+		if t.window.Equal(window) {
+			t.flush()
+		}
+	})
+}
+
+// DefaultTimestampFormat is the layout of the time prefixed to each line of
+// output with -ts-output, unless another one is given.
+const DefaultTimestampFormat = "15:04:05.000"
+
+// stamper prefixes each line of output with the time its first byte was
+// written.
+type stamper struct {
+	w      io.Writer
+	layout string
+
+	mu sync.Mutex
+	// mid is set while a line is partly written.
+	mid bool
+}
+
+func newStamper(w io.Writer, layout string) *stamper {
+	return &stamper{w: w, layout: layout}
+}
+
+func (s *stamper) Write(p []byte) (int, error) {
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	var b bytes.Buffer
+	for rest := p; len(rest) > 0; {
+		if !s.mid {
+			b.WriteString("[" + time.Now().Format(s.layout) + "] ")
+		}
+		line := rest
+		if i := bytes.IndexByte(rest, '\n'); i >= 0 {
+			line = rest[:i+1]
+		}
+		b.Write(line)
+		s.mid = line[len(line)-1] != '\n'
+		rest = rest[len(line):]
+	}
+	if _, err := s.w.Write(b.Bytes()); err != nil {
+		return 0, err
+	}
+	return len(p), nil
+}
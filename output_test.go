@@ -0,0 +1,61 @@
+package f5
+
+import (
+	"bytes"
+	"fmt"
+	"regexp"
+	"testing"
+	"time"
+)
+
+func TestStamper(t *testing.T) {
+	var out bytes.Buffer
+	s := newStamper(&out, DefaultTimestampFormat)
+	fmt.Fprint(s, "one\ntwo\nthr")
+	fmt.Fprint(s, "ee\n")
+	fmt.Fprint(s, "\nfour")
+	ts := `\[\d\d:\d\d:\d\d\.\d{3}\] `
+	want := regexp.MustCompile(`^` + ts + `one\n` + ts + `two\n` + ts + `three\n` + ts + `\n` + ts + `four$`)
+	if !want.MatchString(out.String()) {
+		t.Errorf("output %q, want each line stamped once", out.String())
+	}
+}
+
+func TestStamperFormat(t *testing.T) {
+	var out bytes.Buffer
+	s := newStamper(&out, "T")
+	n, err := fmt.Fprint(s, "a\nb\n")
+	if err != nil || n != 4 {
+		t.Errorf("wrote %d, %v, want 4 bytes", n, err)
+	}
+	if want := "[T] a\n[T] b\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
+
+// TestThrottle checks that lines over the limit are dropped, and that the
+// count dropped is told at the end of the second even with no more output.
+func TestThrottle(t *testing.T) {
+	var out bytes.Buffer
+	th := newThrottle(2)
+	fmt.Fprint(th.writer(&out), "a\nb\nc\nd\n")
+	time.Sleep(1200 * time.Millisecond)
+	th.mu.Lock()
+	defer th.mu.Unlock()
+	if want := "a\nb\n[output throttled]\n[2 lines dropped]\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
+
+// TestThrottlePartial checks that output without newlines counts against
+// the limit.
+func TestThrottlePartial(t *testing.T) {
+	var out bytes.Buffer
+	w := newThrottle(2).writer(&out)
+	for _, s := range []string{"10%", "\r20%", "\r30%"} {
+		fmt.Fprint(w, s)
+	}
+	if want := "10%\r20%[output throttled]\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
@@ -0,0 +1,46 @@
+package f5
+
+import (
+	"os"
+	"path/filepath"
+	"strconv"
+)
+
+// writePIDFile atomically replaces the pid file with pid, so that readers
+// never see a partially written file.
+func (r *Run) writePIDFile(pid int) error {
+	if r.cfg.PIDFile == "" {
+		return nil
+	}
+	r.pidFile.Lock()
+	defer r.pidFile.Unlock()
+	f, err := os.CreateTemp(filepath.Dir(r.cfg.PIDFile), ".f5-pid-*")
+	if err != nil {
+		return err
+	}
+	defer os.Remove(f.Name())
+	if _, err := f.WriteString(strconv.Itoa(pid) + "\n"); err != nil {
+		f.Close()
+		return err
+	}
+	if err := f.Close(); err != nil {
+		return err
+	}
+	return os.Rename(f.Name(), r.cfg.PIDFile)
+}
+
+// removePIDFile removes the pid file once the run p exited, unless
+// another run is current by then, or when shutting down if p is nil.
+func (r *Run) removePIDFile(p *proc) {
+	if r.cfg.PIDFile == "" {
+		return
+	}
+	r.pidFile.Lock()
+	defer r.pidFile.Unlock()
+	if current, _ := r.status(); p != nil && current != p {
+		return
+	}
+	if err := os.Remove(r.cfg.PIDFile); err != nil && !os.IsNotExist(err) {
+		r.printf(colorError, "Cannot remove pid file: %v", err)
+	}
+}
@@ -0,0 +1,46 @@
+package f5
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"strconv"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// TestPIDFile checks that the pid file follows restarts, and is removed
+// once the command exits on its own.
+func TestPIDFile(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "f5.pid")
+	r, err := NewWithConfig(Config{PIDFile: file}, "sleep", "60")
+	if err != nil {
+		t.Fatal(err)
+	}
+	r.logger = log.New(io.Discard, "", 0)
+	r.out = io.Discard
+	t.Cleanup(r.Close)
+	ctx := context.Background()
+
+	r.restartFor(ctx, trigger{reason: "start"})
+	r.restartFor(ctx, trigger{reason: "key"})
+	p, _ := r.status()
+	time.Sleep(100 * time.Millisecond)
+	b, err := os.ReadFile(file)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if want := strconv.Itoa(p.Pid) + "\n"; string(b) != want {
+		t.Errorf("pid file %q after a restart, want %q", b, want)
+	}
+
+	p.Signal(syscall.SIGTERM)
+	<-p.done
+	time.Sleep(100 * time.Millisecond)
+	if _, err := os.Stat(file); !os.IsNotExist(err) {
+		t.Errorf("pid file left after the command exited: %v", err)
+	}
+}
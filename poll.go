@@ -0,0 +1,130 @@
+package f5
+
+import (
+	"context"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// pollInterval is how often the directories that cannot be watched are
+// checked for changes.
+const pollInterval = time.Second
+
+// poller tracks the modification times of the files in directories that
+// are polled rather than watched.
+type poller struct {
+	mu    sync.Mutex
+	dirs  map[string]bool
+	mtime map[string]time.Time
+}
+
+// add starts polling the directory, remembering the current state of its
+// files so they are not reported as changed.
+func (p *poller) add(dir string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	if p.dirs == nil {
+		p.dirs = map[string]bool{}
+		p.mtime = map[string]time.Time{}
+	}
+	p.dirs[dir] = true
+	files, subdirs := scan(dir)
+	for path, mtime := range files {
+		p.mtime[path] = mtime
+	}
+	for _, d := range subdirs {
+		p.mtime[d] = time.Time{}
+	}
+}
+
+// remove stops polling the directory.
+func (p *poller) remove(dir string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	delete(p.dirs, dir)
+}
+
+// list returns the polled directories.
+func (p *poller) list() []string {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	dirs := []string{}
+	for d := range p.dirs {
+		dirs = append(dirs, d)
+	}
+	return dirs
+}
+
+// changes returns the files modified, and the directories created, since
+// the previous call.
+func (p *poller) changes() (changed, created []string) {
+	p.mu.Lock()
+	defer p.mu.Unlock()
+	for dir := range p.dirs {
+		files, subdirs := scan(dir)
+		for path, mtime := range files {
+			if last, ok := p.mtime[path]; !ok || !mtime.Equal(last) {
+				changed = append(changed, path)
+			}
+			p.mtime[path] = mtime
+		}
+		for _, d := range subdirs {
+			if _, ok := p.mtime[d]; !ok {
+				created = append(created, d)
+			}
+			p.mtime[d] = time.Time{}
+		}
+	}
+	return changed, created
+}
+
+// scan returns the modification times of the files in dir, and its
+// subdirectories.
+func scan(dir string) (map[string]time.Time, []string) {
+	files := map[string]time.Time{}
+	subdirs := []string{}
+	entries, err := os.ReadDir(dir)
+	if err != nil {
+		return files, subdirs
+	}
+	for _, e := range entries {
+		path := filepath.Join(dir, e.Name())
+		if e.IsDir() {
+			subdirs = append(subdirs, path)
+			continue
+		}
+		if fi, err := e.Info(); err == nil && fi.Mode().IsRegular() {
+			files[path] = fi.ModTime()
+		}
+	}
+	return files, subdirs
+}
+
+// poll reports the changes in polled directories until ctx is done.
+func (r *Run) poll(ctx context.Context) {
+	ticker := time.NewTicker(pollInterval)
+	defer ticker.Stop()
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+		changed, created := r.poller.changes()
+		for _, dir := range created {
+			r.watchNew(dir)
+		}
+		for _, path := range changed {
+			event := fsnotify.Event{Name: path, Op: fsnotify.Write}
+			if reason := r.reject(event); reason != "" {
+				r.debugf("rejected polled %s: %s", path, reason)
+				continue
+			}
+			r.changed(path)
+		}
+	}
+}
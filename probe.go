@@ -0,0 +1,50 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"os/exec"
+	"time"
+)
+
+// defaultTriggerInterval is how often the trigger command is run when no
+// interval is configured.
+const defaultTriggerInterval = 2 * time.Second
+
+// probe runs the trigger command every interval until ctx is done, and
+// restarts the command whenever its output differs from the previous run.
+func (r *Run) probe(ctx context.Context) {
+	if r.cfg.TriggerCommand == "" {
+		return
+	}
+	interval := r.cfg.TriggerInterval
+	if interval <= 0 {
+		interval = defaultTriggerInterval
+	}
+	r.usagef(colorInfo, "Restarting when the output of %q changes, checking every %s", r.cfg.TriggerCommand, interval)
+	ticker := time.NewTicker(interval)
+	defer ticker.Stop()
+	// last is the output of the latest successful run, once there is one.
+	var last []byte
+	seen := false
+	for {
+		out, err := exec.CommandContext(ctx, "sh", "-c", r.cfg.TriggerCommand).Output()
+		if ctx.Err() != nil {
+			return
+		}
+		if err != nil {
+			r.printf(colorError, "Trigger command %q failed: %v", r.cfg.TriggerCommand, err)
+		} else {
+			if seen && !bytes.Equal(out, last) {
+				r.printf(colorSuccess, "Output of %q changed", r.cfg.TriggerCommand)
+				r.send(trigger{reason: "trigger-cmd"})
+			}
+			last, seen = out, true
+		}
+		select {
+		case <-ctx.Done():
+			return
+		case <-ticker.C:
+		}
+	}
+}
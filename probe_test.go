@@ -0,0 +1,39 @@
+package f5
+
+import (
+	"context"
+	"io"
+	"log"
+	"os"
+	"path/filepath"
+	"testing"
+	"time"
+)
+
+// TestProbeAfterFailure checks that the first successful run of the
+// trigger command after failed ones is not taken for a change.
+func TestProbeAfterFailure(t *testing.T) {
+	file := filepath.Join(t.TempDir(), "version")
+	r := newTestRun(t, Config{
+		TriggerCommand:  "cat " + file,
+		TriggerInterval: 50 * time.Millisecond,
+	})
+	r.logger = log.New(io.Discard, "", 0)
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	go r.probe(ctx)
+
+	time.Sleep(100 * time.Millisecond)
+	if err := os.WriteFile(file, []byte("1\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarts(r); len(got) != 0 {
+		t.Errorf("restarts %+v once the command succeeds, want none", got)
+	}
+	if err := os.WriteFile(file, []byte("2\n"), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	if got := restarts(r); len(got) != 1 || got[0].reason != "trigger-cmd" {
+		t.Errorf("restarts %+v once the output changed, want one", got)
+	}
+}
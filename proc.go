@@ -0,0 +1,186 @@
+package f5
+
+import (
+	"errors"
+	"fmt"
+	"os"
+	"os/exec"
+	"syscall"
+	"time"
+)
+
+// proc is one started instance of the command. Each proc has exactly one
+// goroutine waiting for it, so the exit of a process that was replaced by
+// a restart is never attributed to the current one.
+type proc struct {
+	*os.Process
+	run     int
+	started time.Time
+
+	// stopped is when f5 stopped the process, if it did.
+	stopped time.Time
+
+	// done is closed when the process has exited, after which err holds
+	// the result of Wait and ended the time of exit.
+	done  chan struct{}
+	err   error
+	ended time.Time
+
+	// pty is the pseudo-terminal of the process with -pty, and output is
+	// closed once its output was all read.
+	pty    *os.File
+	output chan struct{}
+}
+
+// exited reports whether the process has exited.
+func (p *proc) exited() bool {
+	select {
+	case <-p.done:
+		return true
+	default:
+		return false
+	}
+}
+
+// wait reaps the process, and reports its exit if it is still the current
+// one, that is, it exited on its own rather than being killed by f5.
+func (r *Run) wait(cmd *exec.Cmd, p *proc) {
+	p.err = cmd.Wait()
+	if r.goArgs != nil {
+		p.err = goResult(p.run, p.err)
+	}
+	if p.pty != nil {
+		p.closePTY()
+	}
+	p.ended = time.Now()
+	close(p.done)
+	if r.cfg.Stdin {
+		r.reclaimTTY(p)
+	}
+	r.mu.Lock()
+	delete(r.live, p)
+	r.mu.Unlock()
+	if r.cfg.GroupOutput {
+		end := fmt.Sprintf("run #%d end (%s, %s)", p.run, exitStatus(p.err), p.ended.Sub(p.started).Round(100*time.Millisecond))
+		fmt.Fprintf(r.out, "%s%s%s\n", r.theme[colorSuccess], banner(end), r.theme.reset())
+	}
+	r.mu.Lock()
+	current := r.proc == p
+	if current && p.err != nil {
+		r.crashes++
+	}
+	r.mu.Unlock()
+	if !current {
+		return
+	}
+	r.removePIDFile(p)
+	code := exitCode(p.err)
+	r.emit(Event{Type: "exit", PID: p.Pid, Run: p.run, Code: &code})
+	ran := time.Since(p.started).Round(time.Millisecond)
+	if p.err != nil {
+		r.printf(colorError, "Process %d exited after %s: %v", p.Pid, ran, p.err)
+	} else {
+		r.printf(colorInfo, "Process %d exited after %s", p.Pid, ran)
+	}
+	r.restartAfterExit(p, code)
+}
+
+// exitRestartDelay is the least time between starts for -restart-on-exit,
+// so that a command exiting right away is not started in a busy loop.
+const exitRestartDelay = time.Second
+
+// restartAfterExit starts the command again after the run p exited with
+// code, when -restart-on-exit lists it.
+func (r *Run) restartAfterExit(p *proc, code int) {
+	listed := false
+	for _, c := range r.cfg.RestartOnExit {
+		listed = listed || c == code
+	}
+	if !listed {
+		return
+	}
+	delay := exitRestartDelay - p.ended.Sub(p.started)
+	if delay < 0 {
+		delay = 0
+	}
+	r.printf(colorInfo, "Process %d exited with %d, starting it again", p.Pid, code)
+	time.AfterFunc(delay, func() { r.send(trigger{reason: "exit"}) })
+}
+
+// DefaultForceAfter is how long a process stopped by f5 has to exit
+// before it is killed, unless Config.ForceAfter is set.
+const DefaultForceAfter = 5 * time.Second
+
+// forceWarn is how often a process that does not exit when stopped is
+// reported with -no-force.
+const forceWarn = 5 * time.Second
+
+// force kills the process p stopped by f5 if it does not exit in time, as
+// it may ignore the signal, or with -no-force reports it until it exits.
+func (r *Run) force(p *proc) {
+	after := r.cfg.ForceAfter
+	if after <= 0 {
+		after = DefaultForceAfter
+	}
+	if r.cfg.NoForce {
+		t := time.NewTicker(forceWarn)
+		defer t.Stop()
+		for {
+			select {
+			case <-p.done:
+				return
+			case <-t.C:
+				r.printf(colorWarn, "Process %d still running %s after being stopped, not killing it with -no-force", p.Pid, time.Since(p.stopped).Round(time.Second))
+			}
+		}
+	}
+	select {
+	case <-p.done:
+		return
+	case <-time.After(after):
+	}
+	r.printf(colorWarn, "Process %d did not exit %s after being stopped, sending sigkill", p.Pid, after)
+	if err := syscall.Kill(r.target(p.Pid), syscall.SIGKILL); err != nil {
+		r.printf(colorError, "Process %d: cannot be killed: %v", p.Pid, err)
+	}
+}
+
+// reap waits for the processes that were stopped to exit, or to be killed
+// by force, so that they are all reaped on Close.
+func (r *Run) reap() {
+	r.mu.Lock()
+	procs := []*proc{}
+	for p := range r.live {
+		procs = append(procs, p)
+	}
+	r.mu.Unlock()
+	for _, p := range procs {
+		<-p.done
+	}
+}
+
+// exitStatus describes the result of Wait, as "exit 1" or the signal that
+// terminated the process.
+func exitStatus(err error) string {
+	if code := exitCode(err); code >= 0 {
+		return fmt.Sprintf("exit %d", code)
+	}
+	return err.Error()
+}
+
+// exitCode returns the exit code for the result of Wait, or -1 if the
+// process was terminated by a signal or could not be waited for.
+func exitCode(err error) int {
+	if err == nil {
+		return 0
+	}
+	var exit *exec.ExitError
+	if errors.As(err, &exit) {
+		return exit.ExitCode()
+	}
+	var app *goExit
+	if errors.As(err, &app) && app.sig == 0 {
+		return app.code
+	}
+	return -1
+}
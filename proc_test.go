@@ -0,0 +1,190 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strconv"
+	"strings"
+	"syscall"
+	"testing"
+	"time"
+)
+
+// children returns the state of each child process of the test, by pid,
+// such as "S" for sleeping or "Z" for a zombie.
+func children(t *testing.T) map[int]string {
+	t.Helper()
+	stats, err := filepath.Glob("/proc/[0-9]*/stat")
+	if err != nil {
+		t.Fatal(err)
+	}
+	states := map[int]string{}
+	for _, s := range stats {
+		b, err := os.ReadFile(s)
+		if err != nil {
+			continue // exited meanwhile
+		}
+		// the command name in parentheses may contain spaces.
+		f := strings.Fields(string(b[strings.LastIndexByte(string(b), ')')+1:]))
+		if len(f) < 2 {
+			continue
+		}
+		if ppid, _ := strconv.Atoi(f[1]); ppid == os.Getpid() {
+			pid, _ := strconv.Atoi(filepath.Base(filepath.Dir(s)))
+			states[pid] = f[0]
+		}
+	}
+	return states
+}
+
+func TestNoZombies(t *testing.T) {
+	if _, err := os.Stat("/proc/self/stat"); err != nil {
+		t.Skip("no /proc")
+	}
+	r, err := NewWithConfig(Config{Roots: []Root{{Dir: t.TempDir()}}}, "sleep", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	ctx := context.Background()
+	for i := 0; i < 5; i++ {
+		r.Restart(ctx)
+	}
+	// a process killed by someone else is reaped too.
+	p, _ := r.status()
+	if err := syscall.Kill(p.Pid, syscall.SIGKILL); err != nil {
+		t.Fatal(err)
+	}
+	select {
+	case <-p.done:
+	case <-time.After(5 * time.Second):
+		t.Fatal("process killed not reaped")
+	}
+	r.Restart(ctx)
+	r.Close()
+	if c := children(t); len(c) > 0 {
+		t.Errorf("children left after Close: %v", c)
+	}
+}
+
+// TestRapidRestarts checks that the processes replaced by restarts are not
+// reported as exiting, nor counted as crashes, however late they exit.
+func TestRapidRestarts(t *testing.T) {
+	r, err := NewWithConfig(Config{Roots: []Root{{Dir: t.TempDir()}}, RestartOnExit: []int{0, 1, 130}}, "sleep", "10")
+	if err != nil {
+		t.Fatal(err)
+	}
+	defer r.Close()
+	events, stop := r.events.subscribe()
+	defer stop()
+	ctx := context.Background()
+	for i := 0; i < 20; i++ {
+		r.Restart(ctx)
+	}
+	current, runs := r.status()
+	if runs != 20 {
+		t.Fatalf("started %d times, want 20", runs)
+	}
+	// wait for the replaced ones to be reaped.
+	for deadline := time.Now().Add(5 * time.Second); ; time.Sleep(10 * time.Millisecond) {
+		r.mu.Lock()
+		live, crashes := len(r.live), r.crashes
+		r.mu.Unlock()
+		if live == 1 {
+			if crashes != 0 {
+				t.Errorf("%d crashes counted, want none", crashes)
+			}
+			break
+		}
+		if time.Now().After(deadline) {
+			t.Fatalf("%d processes not reaped", live-1)
+		}
+	}
+	for {
+		select {
+		case e := <-events:
+			if e.Type == "exit" {
+				t.Errorf("exit of process %d run %d reported", e.PID, e.Run)
+			}
+			continue
+		default:
+		}
+		break
+	}
+	if p, _ := r.status(); p != current || p.exited() {
+		t.Error("the current process was replaced or exited")
+	}
+}
+
+func TestForceAfter(t *testing.T) {
+	for _, noForce := range []bool{false, true} {
+		r, err := NewWithConfig(Config{
+			Roots:      []Root{{Dir: t.TempDir()}},
+			ForceAfter: 100 * time.Millisecond,
+			NoForce:    noForce,
+			Color:      "never",
+		}, "sh", "-c", `trap "" INT; echo ready; sleep 10`)
+		if err != nil {
+			t.Fatal(err)
+		}
+		var out bytes.Buffer
+		r.logger = log.New(&out, "", 0)
+		ready := newReadiness(regexp.MustCompile("ready"), 0)
+		r.stdout = ready.tap(r.stdout)
+		r.Restart(context.Background())
+		<-ready.ready
+		p, _ := r.status()
+		r.kill()
+		select {
+		case <-p.done:
+			if noForce {
+				t.Error("-no-force: killed the process ignoring the interrupt")
+			}
+		case <-time.After(time.Second):
+			if !noForce {
+				t.Error("process ignoring the interrupt not killed")
+			}
+			syscall.Kill(-p.Pid, syscall.SIGKILL)
+		}
+		// once done reporting.
+		r.Close()
+		warned := strings.Contains(out.String(), "did not exit 100ms after being stopped, sending sigkill")
+		if warned == noForce {
+			t.Errorf("-no-force=%v: reported %q", noForce, out.String())
+		}
+	}
+}
+
+func TestRestartOnExit(t *testing.T) {
+	tests := []struct {
+		code    string
+		restart bool
+	}{
+		{"75", true},
+		{"0", true},
+		{"3", false},
+	}
+	for _, tt := range tests {
+		r, err := NewWithConfig(Config{Roots: []Root{{Dir: t.TempDir()}}, RestartOnExit: []int{0, 75}}, "sh", "-c", "exit "+tt.code)
+		if err != nil {
+			t.Fatal(err)
+		}
+		r.Restart(context.Background())
+		select {
+		case tr := <-r.restart:
+			if !tt.restart {
+				t.Errorf("exit %s: restarted, want not", tt.code)
+			} else if tr.reason != "exit" {
+				t.Errorf("exit %s: restarted for %q, want exit", tt.code, tr.reason)
+			}
+		case <-time.After(exitRestartDelay + time.Second):
+			if tt.restart {
+				t.Errorf("exit %s: not restarted", tt.code)
+			}
+		}
+		r.Close()
+	}
+}
@@ -0,0 +1,66 @@
+package f5
+
+import (
+	"context"
+	"io"
+	"os"
+	"os/exec"
+	"os/signal"
+	"syscall"
+	"time"
+
+	"github.com/creack/pty"
+)
+
+// ptyDrain is how long the output of a command on a pseudo-terminal is
+// read after it exited, in case processes it left behind keep the
+// terminal open.
+const ptyDrain = time.Second
+
+// startPTY starts the command on a new pseudo-terminal of the size of f5's
+// terminal, and returns the terminal to read the output from.
+func (r *Run) startPTY(cmd *exec.Cmd) (*os.File, error) {
+	cmd.Stdin, cmd.Stdout, cmd.Stderr = nil, nil, nil
+	size, err := pty.GetsizeFull(os.Stdin)
+	if err != nil {
+		size = nil
+	}
+	// a new session is also a new process group, so the command is
+	// stopped the same way.
+	return pty.StartWithAttrs(cmd, size, &syscall.SysProcAttr{Setsid: true, Setctty: true, Credential: r.cred})
+}
+
+// copyPTY passes the output of the command on the pseudo-terminal on to w,
+// as a single stream, until the terminal is closed.
+func (r *Run) copyPTY(p *proc, w io.Writer) {
+	defer close(p.output)
+	io.Copy(w, p.pty)
+}
+
+// closePTY waits for the output of the exited command to be read, and
+// closes its pseudo-terminal.
+func (p *proc) closePTY() {
+	select {
+	case <-p.output:
+	case <-time.After(ptyDrain):
+	}
+	p.pty.Close()
+}
+
+// resizePTY keeps the pseudo-terminal of the command the size of f5's
+// terminal until ctx is done.
+func (r *Run) resizePTY(ctx context.Context) {
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	defer signal.Stop(winch)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-winch:
+		}
+		if p, _ := r.status(); p != nil && p.pty != nil && !p.exited() {
+			pty.InheritSize(os.Stdin, p.pty)
+		}
+	}
+}
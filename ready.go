@@ -0,0 +1,65 @@
+package f5
+
+import (
+	"context"
+	"io"
+	"regexp"
+	"sync"
+	"time"
+)
+
+// readiness finds the line of output telling that a run of the command is
+// ready, for -ready-regex. Each run has its own, reading its own output, so
+// that the output of another run, such as the previous one going on with
+// -blue-green, does not count.
+type readiness struct {
+	re    *regexp.Regexp
+	max   int
+	ready chan struct{}
+	once  sync.Once
+}
+
+func newReadiness(re *regexp.Regexp, max int) *readiness {
+	return &readiness{re: re, max: max, ready: make(chan struct{})}
+}
+
+// tap returns w, looking at the lines of output written to it.
+func (x *readiness) tap(w io.Writer) io.Writer {
+	return newLineTap(w, x.max, x.line)
+}
+
+func (x *readiness) line(s string) {
+	if x.re.MatchString(s) {
+		x.once.Do(func() { close(x.ready) })
+	}
+}
+
+// awaitReady reports when the run p is ready, and runs the -on-ready
+// command then. It gives up when the run exits, or warns once
+// -ready-timeout passes.
+func (r *Run) awaitReady(ctx context.Context, p *proc, ready <-chan struct{}) {
+	var timeout <-chan time.Time
+	if r.cfg.ReadyTimeout > 0 {
+		t := time.NewTimer(r.cfg.ReadyTimeout)
+		defer t.Stop()
+		timeout = t.C
+	}
+	select {
+	case <-ctx.Done():
+		return
+	case <-p.done:
+		return
+	case <-timeout:
+		r.printf(colorWarn, "Process %d not ready after %s", p.Pid, r.cfg.ReadyTimeout)
+		return
+	case <-ready:
+	}
+	r.printf(colorSuccess, "Process %d ready after %s", p.Pid, time.Since(p.started).Round(time.Millisecond))
+	r.emit(Event{Type: "ready", PID: p.Pid, Run: p.run})
+	if r.cfg.OnReady != "" {
+		r.hook(ctx, "on-ready", r.cfg.OnReady)
+	}
+	if r.cfg.WarmupURL != "" {
+		r.warmUp(ctx, p, false)
+	}
+}
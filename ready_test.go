@@ -0,0 +1,95 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"log"
+	"os"
+	"path/filepath"
+	"regexp"
+	"strings"
+	"testing"
+	"time"
+)
+
+// isClosed reports whether c is closed.
+func isClosed(c <-chan struct{}) bool {
+	select {
+	case <-c:
+		return true
+	default:
+		return false
+	}
+}
+
+func TestReadinessPerRun(t *testing.T) {
+	re := regexp.MustCompile(`^listening on \d+$`)
+	var out bytes.Buffer
+	prev, next := newReadiness(re, 0), newReadiness(re, 0)
+	prevOut, nextOut := prev.tap(&out), next.tap(&out)
+
+	fmt.Fprint(nextOut, "starting\nlisten")
+	// the previous run going on prints the line too.
+	fmt.Fprint(prevOut, "listening on 8080\n")
+	if !isClosed(prev.ready) {
+		t.Error("previous run not ready after its ready line")
+	}
+	if isClosed(next.ready) {
+		t.Fatal("new run ready after the ready line of the previous run")
+	}
+	fmt.Fprint(nextOut, "ing on 8081\n")
+	if !isClosed(next.ready) {
+		t.Error("new run not ready after its ready line written in two parts")
+	}
+	// more matching lines are fine.
+	fmt.Fprint(nextOut, "listening on 8081\n")
+	if want := "starting\nlistenlistening on 8080\ning on 8081\nlistening on 8081\n"; out.String() != want {
+		t.Errorf("output %q, want %q", out.String(), want)
+	}
+}
+
+func TestAwaitReady(t *testing.T) {
+	marker := filepath.Join(t.TempDir(), "ready")
+	r := newTestRun(t, Config{ReadyRegex: "started", OnReady: "touch " + marker, Color: "never"})
+	var out bytes.Buffer
+	r.logger = log.New(&out, "", 0)
+	events, stop := r.events.subscribe()
+	defer stop()
+
+	x := newReadiness(r.readyRegex, 0)
+	fmt.Fprint(x.tap(&bytes.Buffer{}), "loading\nserver started on :8080\n")
+	p := &proc{Process: &os.Process{Pid: 42}, run: 1, started: time.Now(), done: make(chan struct{})}
+	r.awaitReady(context.Background(), p, x.ready)
+	if !strings.Contains(out.String(), "Process 42 ready after") {
+		t.Errorf("reported %q, want the process ready", out.String())
+	}
+	if e := <-events; e.Type != "ready" || e.PID != 42 {
+		t.Errorf("event %+v, want ready for process 42", e)
+	}
+	if _, err := os.Stat(marker); err != nil {
+		t.Errorf("-on-ready not run: %v", err)
+	}
+}
+
+func TestAwaitReadyTimeout(t *testing.T) {
+	r := newTestRun(t, Config{ReadyRegex: "started", ReadyTimeout: 50 * time.Millisecond, Color: "never"})
+	var out bytes.Buffer
+	r.logger = log.New(&out, "", 0)
+	x := newReadiness(r.readyRegex, 0)
+	fmt.Fprint(x.tap(&bytes.Buffer{}), "loading\n")
+	p := &proc{Process: &os.Process{Pid: 42}, done: make(chan struct{})}
+	r.awaitReady(context.Background(), p, x.ready)
+	if want := "Process 42 not ready after 50ms\n"; out.String() != want {
+		t.Errorf("reported %q, want %q", out.String(), want)
+	}
+
+	// a run exiting before it is ready is not reported.
+	out.Reset()
+	r.cfg.ReadyTimeout = 0
+	close(p.done)
+	r.awaitReady(context.Background(), p, x.ready)
+	if out.Len() > 0 {
+		t.Errorf("reported %q for an exited run, want nothing", out.String())
+	}
+}
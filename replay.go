@@ -0,0 +1,90 @@
+package f5
+
+import (
+	"fmt"
+	"io"
+	"sync"
+)
+
+// replay keeps the last lines of the output of the current and previous
+// runs, so the previous run's output can be shown again after a restart.
+type replay struct {
+	max int
+	// maxBytes, if set, bounds the size of the lines kept for a run.
+	maxBytes int
+
+	mu      sync.Mutex
+	lines   []string
+	size    int
+	prev    []string
+	prevRun int
+}
+
+func newReplay(max, maxBytes int) *replay {
+	return &replay{max: max, maxBytes: maxBytes}
+}
+
+// writer returns a writer passing output on to w while keeping its lines.
+func (h *replay) writer(w io.Writer) io.Writer {
+	return newLineTap(w, h.maxBytes, h.add)
+}
+
+func (h *replay) add(line string) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.lines = append(h.lines, line)
+	h.size += len(line)
+	// trim once in a while rather than on every line.
+	if len(h.lines) >= 2*h.max || h.maxBytes > 0 && h.size > 2*h.maxBytes {
+		h.trim()
+		h.lines = append([]string(nil), h.lines...)
+	}
+}
+
+// trim drops the oldest lines beyond the limits.
+func (h *replay) trim() {
+	if len(h.lines) > h.max {
+		for _, l := range h.lines[:len(h.lines)-h.max] {
+			h.size -= len(l)
+		}
+		h.lines = h.lines[len(h.lines)-h.max:]
+	}
+	for h.maxBytes > 0 && h.size > h.maxBytes && len(h.lines) > 0 {
+		h.size -= len(h.lines[0])
+		h.lines = h.lines[1:]
+	}
+}
+
+// rotate makes the kept lines those of the previous run, which was the
+// given run.
+func (h *replay) rotate(run int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	h.trim()
+	h.prev, h.prevRun = h.lines, run
+	h.lines, h.size = nil, 0
+}
+
+// previous returns the kept lines of the previous run, and its number.
+func (h *replay) previous() ([]string, int) {
+	h.mu.Lock()
+	defer h.mu.Unlock()
+	return h.prev, h.prevRun
+}
+
+// showReplay prints the kept output of the previous run again.
+func (r *Run) showReplay() {
+	if r.replay == nil {
+		return
+	}
+	lines, run := r.replay.previous()
+	if run == 0 {
+		r.printf(colorWarn, "No previous run to show the output of")
+		return
+	}
+	fmt.Fprintf(r.out, "%s%s%s\n", r.theme[colorSuccess], banner(fmt.Sprintf("run #%d output, last %d lines", run, len(lines))), r.theme.reset())
+	for _, l := range lines {
+		fmt.Fprintln(r.out, l)
+	}
+	fmt.Fprintf(r.out, "%s%s%s\n", r.theme[colorSuccess], banner(fmt.Sprintf("run #%d output end", run)), r.theme.reset())
+}
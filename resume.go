@@ -0,0 +1,162 @@
+package f5
+
+import (
+	"crypto/sha256"
+	"encoding/hex"
+	"encoding/json"
+	"os"
+	"path/filepath"
+	"sync"
+	"time"
+
+	"github.com/fsnotify/fsnotify"
+)
+
+// resumeShown is how many of the files changed while f5 was not running
+// are listed.
+const resumeShown = 10
+
+// resume keeps the latest modification time of the watched files seen by
+// f5 between its runs in a directory, for -resume, so that it can tell
+// which files changed while it was not running.
+type resume struct {
+	// path is the state file, stateFile.
+	path string
+
+	mu    sync.Mutex
+	state resumeState
+}
+
+// resumeState is the content of the -resume state file, as JSON.
+type resumeState struct {
+	// Dir is the working directory of f5.
+	Dir string `json:"dir"`
+	// ModTime is the latest modification time of a watched file f5 saw.
+	ModTime time.Time `json:"modtime"`
+}
+
+// stateFile returns the -resume state file of f5 run in dir: f5/resume-
+// followed by a hash of dir and .json, in the user's cache directory.
+func stateFile(dir string) (string, error) {
+	cache, err := os.UserCacheDir()
+	if err != nil {
+		return "", err
+	}
+	sum := sha256.Sum256([]byte(dir))
+	return filepath.Join(cache, "f5", "resume-"+hex.EncodeToString(sum[:8])+".json"), nil
+}
+
+// openResume reads the state left by the previous run of f5 in the
+// working directory, if any.
+func openResume() (*resume, error) {
+	dir, err := os.Getwd()
+	if err != nil {
+		return nil, err
+	}
+	path, err := stateFile(dir)
+	if err != nil {
+		return nil, err
+	}
+	rs := &resume{path: path, state: resumeState{Dir: dir}}
+	b, err := os.ReadFile(path)
+	if os.IsNotExist(err) {
+		return rs, nil
+	}
+	if err != nil {
+		return nil, err
+	}
+	if err := json.Unmarshal(b, &rs.state); err != nil {
+		return nil, err
+	}
+	rs.state.Dir = dir
+	return rs, nil
+}
+
+// seen records the modification time of a watched file, saving it when
+// it is the latest yet.
+func (rs *resume) seen(t time.Time) error {
+	rs.mu.Lock()
+	defer rs.mu.Unlock()
+	if !t.After(rs.state.ModTime) {
+		return nil
+	}
+	rs.state.ModTime = t
+	b, err := json.Marshal(rs.state)
+	if err != nil {
+		return err
+	}
+	if err := os.MkdirAll(filepath.Dir(rs.path), 0o755); err != nil {
+		return err
+	}
+	return os.WriteFile(rs.path, b, 0o644)
+}
+
+// resumed reports the watched files in dirs and files changed since f5
+// last ran in the working directory, before the command first starts.
+func (r *Run) resumed(dirs, files []string) {
+	since := r.resume.state.ModTime
+	var latest time.Time
+	var changed []string
+	check := func(path string) {
+		if r.reject(fsnotify.Event{Name: path, Op: fsnotify.Write}) != "" {
+			return
+		}
+		fi, err := os.Stat(path)
+		if err != nil || !fi.Mode().IsRegular() {
+			return
+		}
+		t := fi.ModTime()
+		if t.After(latest) {
+			latest = t
+		}
+		if !since.IsZero() && t.After(since) {
+			changed = append(changed, path)
+		}
+	}
+	for _, d := range dirs {
+		entries, err := os.ReadDir(d)
+		if err != nil {
+			continue
+		}
+		for _, e := range entries {
+			if !e.IsDir() {
+				check(filepath.Join(d, e.Name()))
+			}
+		}
+	}
+	for _, f := range files {
+		check(f)
+	}
+	switch {
+	case since.IsZero():
+		r.debugf("no -resume state in %s yet", r.resume.path)
+	case len(changed) == 0:
+		r.usagef(colorInfo, "No watched file changed since f5 last ran")
+	default:
+		r.usagef(colorSuccess, "Watched files changed since f5 last ran: %d", len(changed))
+		for i, f := range changed {
+			if i == resumeShown {
+				r.usagef(colorSuccess, "     and %d more", len(changed)-i)
+				break
+			}
+			r.usagef(colorSuccess, "%3d. %s", i+1, r.paintPath(colorSuccess, f))
+		}
+	}
+	if err := r.resume.seen(latest); err != nil {
+		r.printf(colorError, "Cannot save -resume state: %v", err)
+	}
+}
+
+// resumeSeen records the change of a watched file for -resume.
+func (r *Run) resumeSeen(path string) {
+	if r.resume == nil {
+		return
+	}
+	fi, err := os.Stat(path)
+	if err != nil {
+		return
+	}
+	if err := r.resume.seen(fi.ModTime()); err != nil {
+		r.printf(colorError, "Cannot save -resume state: %v", err)
+	}
+}
@@ -0,0 +1,258 @@
+package f5
+
+import (
+	"fmt"
+	"io/fs"
+	"os"
+	"path/filepath"
+	"strings"
+	"sync"
+)
+
+// watchFile is the name of the optional file in a root listing patterns
+// to watch, and with a "!" prefix, patterns to ignore.
+const watchFile = ".f5watch"
+
+// ignoreFile is the name of the optional files listing patterns to
+// ignore, in the root or any directory below it, like .gitignore.
+const ignoreFile = ".f5ignore"
+
+// root is a watched directory tree with its extension and pattern filter.
+type root struct {
+	dir string
+	// file, if set, is the only file watched, and dir the directory it
+	// is in.
+	file       string
+	extensions map[string]bool
+	manifests  bool
+	shebang    bool
+	all        bool
+	watchDir   func(dir string, entries []fs.DirEntry) bool
+	// ignoreFiles are the names of the ignore files read in each
+	// directory, by increasing precedence.
+	ignoreFiles []string
+	rules       matcher
+
+	// loaded holds the directories whose ignore file was read.
+	mu     sync.Mutex
+	loaded map[string]bool
+}
+
+// newRoot returns the root for rc, filtered by the extensions and patterns
+// of cfg unless the root sets its own.
+func newRoot(rc Root, cfg Config) (*root, error) {
+	dir, err := filepath.Abs(rc.Dir)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(dir)
+	if err != nil {
+		return nil, err
+	}
+	if !info.IsDir() {
+		return nil, fmt.Errorf("%s is not a directory", dir)
+	}
+	extensions := cfg.WatchedExtensions()
+	if len(rc.Extensions) > 0 {
+		extensions = rc.Extensions
+	}
+	rt := root{
+		dir:         dir,
+		extensions:  map[string]bool{},
+		manifests:   cfg.WatchManifests,
+		shebang:     cfg.DetectShebang,
+		all:         cfg.All,
+		watchDir:    cfg.WatchDir,
+		ignoreFiles: append(append([]string(nil), cfg.IgnoreFiles...), ignoreFile),
+		loaded:      map[string]bool{},
+	}
+	for _, e := range extensions {
+		rt.extensions[normalizeExt(e)] = true
+	}
+	// the command line overrides the watch and ignore files, including
+	// the nested ignore files read later on.
+	if err := rt.rules.addFile(filepath.Join(dir, watchFile), "", true); err != nil {
+		return nil, err
+	}
+	if err := rt.loadIgnore(dir); err != nil {
+		return nil, err
+	}
+	rt.rules.addFinal(true, cfg.Include...)
+	rt.rules.addFinal(false, cfg.Ignore...)
+	rt.rules.addFinal(false, rc.Ignore...)
+	return &rt, nil
+}
+
+// newFileRoot returns the root watching just the file at path.
+func newFileRoot(path string) *root {
+	return &root{dir: filepath.Dir(path), file: path}
+}
+
+// newExtraRoot returns the root for an -also-watch path, which may be a
+// directory or a single file.
+func newExtraRoot(path string, cfg Config) (*root, error) {
+	abs, err := filepath.Abs(path)
+	if err != nil {
+		return nil, err
+	}
+	info, err := os.Stat(abs)
+	if err != nil {
+		return nil, err
+	}
+	if info.IsDir() {
+		return newRoot(Root{Dir: abs}, cfg)
+	}
+	if !info.Mode().IsRegular() {
+		return nil, fmt.Errorf("%s is not a directory or regular file", abs)
+	}
+	return newFileRoot(abs), nil
+}
+
+// loadIgnore adds the patterns of the ignore files in dir, if any, once.
+// The patterns of nested ignore files match inside their directory only,
+// and take precedence there.
+func (rt *root) loadIgnore(dir string) error {
+	rt.mu.Lock()
+	defer rt.mu.Unlock()
+	if rt.loaded[dir] {
+		return nil
+	}
+	rt.loaded[dir] = true
+	for _, name := range rt.ignoreFiles {
+		if name == "" {
+			continue
+		}
+		if err := rt.rules.addFile(filepath.Join(dir, name), rt.rel(dir), false); err != nil {
+			return err
+		}
+	}
+	return nil
+}
+
+// contains reports whether path is inside the root.
+func (rt *root) contains(path string) bool {
+	if rt.file != "" {
+		return path == rt.file
+	}
+	return within(rt.dir, path)
+}
+
+// within reports whether path is dir or inside it.
+func within(dir, path string) bool {
+	rel, err := filepath.Rel(dir, path)
+	return err == nil && rel != ".." && !strings.HasPrefix(rel, ".."+string(filepath.Separator))
+}
+
+// rel returns the slash separated path relative to the root, or "" for
+// the root itself.
+func (rt *root) rel(path string) string {
+	rel, err := filepath.Rel(rt.dir, path)
+	if err != nil || rel == "." {
+		return ""
+	}
+	return filepath.ToSlash(rel)
+}
+
+// supported reports whether the file is watched, as decided by the first
+// of these that applies:
+//   - a root of a single file watches that file only;
+//   - all files are watched with -all;
+//   - dependency manifests are watched with -watch-manifests;
+//   - the patterns matching the file, or else its closest parent directory
+//     matched, include or exclude it;
+//   - with include patterns, no other file is watched;
+//   - an extensionless file is watched if it is a script, with
+//     -detect-shebang;
+//   - any other file is watched if it has one of the root's extensions.
+func (rt *root) supported(path string) bool {
+	if rt.file != "" {
+		return path == rt.file
+	}
+	if rt.all {
+		return true
+	}
+	if rt.manifests && manifests[filepath.Base(path)] {
+		return true
+	}
+	rel := rt.rel(path)
+	for p, isDir := rel, false; p != "" && p != "."; p, isDir = pathDir(p), true {
+		if include, ok := rt.rules.decide(p, isDir); ok {
+			return include
+		}
+	}
+	if rt.rules.includes {
+		return false
+	}
+	ext := filepath.Ext(path)
+	if ext == "" && rt.shebang {
+		return hasShebang(path)
+	}
+	return rt.extensions[ext]
+}
+
+// ignored reports whether the path, or any of its parent directories below
+// the root, is excluded by a pattern.
+func (rt *root) ignored(path string, isDir bool) bool {
+	rel := rt.rel(path)
+	if rel == "" {
+		return false
+	}
+	segs := strings.Split(rel, "/")
+	for i := range segs {
+		dir := isDir || i < len(segs)-1
+		if include, ok := rt.rules.decide(strings.Join(segs[:i+1], "/"), dir); ok && !include {
+			return true
+		}
+	}
+	return false
+}
+
+// pathDir is path.Dir for relative slash separated paths, returning "" at
+// the top.
+func pathDir(p string) string {
+	if i := strings.LastIndex(p, "/"); i >= 0 {
+		return p[:i]
+	}
+	return ""
+}
+
+// rootOf returns the innermost root containing path, or nil. A file
+// watched by itself takes precedence over the directory it is in, so that
+// it is not filtered like the other files there.
+func (r *Run) rootOf(path string) *root {
+	var found *root
+	for _, rt := range r.roots {
+		if rt.file == path {
+			return rt
+		}
+		if rt.contains(path) && (found == nil || len(rt.dir) > len(found.dir)) {
+			found = rt
+		}
+	}
+	return found
+}
+
+// allowed reports whether dir may be watched under the -only-dir allowlist,
+// and whether the walk has to descend into it to reach an allowed one.
+func (r *Run) allowed(dir string) (watch, descend bool) {
+	if len(r.only) == 0 {
+		return true, true
+	}
+	for _, o := range r.only {
+		if within(o, dir) {
+			return true, true
+		}
+		if within(dir, o) {
+			descend = true
+		}
+	}
+	return false, descend
+}
+
+// normalizeExt adds the leading dot to an extension if it is missing.
+func normalizeExt(e string) string {
+	if !strings.HasPrefix(e, ".") {
+		return "." + e
+	}
+	return e
+}
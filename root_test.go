@@ -0,0 +1,119 @@
+package f5
+
+import (
+	"os"
+	"path/filepath"
+	"reflect"
+	"testing"
+)
+
+// writeFile writes content to the file in dir, creating its directory.
+func writeFile(t testing.TB, dir, name, content string) string {
+	t.Helper()
+	path := filepath.Join(dir, name)
+	if err := os.MkdirAll(filepath.Dir(path), 0o755); err != nil {
+		t.Fatal(err)
+	}
+	if err := os.WriteFile(path, []byte(content), 0o644); err != nil {
+		t.Fatal(err)
+	}
+	return path
+}
+
+func TestNestedIgnoreFiles(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".f5ignore", "tmp\n")
+	// a nested file re-includes what the command line and the root file
+	// ignore, and ignores more.
+	writeFile(t, dir, "sub/.f5ignore", "!gen\n!tmp\nlocal\n")
+	mkdirs(t, dir, "gen", "tmp", "local", "sub/gen", "sub/tmp", "sub/local")
+	rt, err := newRoot(Root{Dir: dir}, Config{Ignore: []string{"gen"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.loadIgnore(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"gen":       true,
+		"tmp":       true,
+		"local":     false,
+		"sub/gen":   true,
+		"sub/tmp":   false,
+		"sub/local": true,
+	}
+	for rel, want := range tests {
+		if got := rt.ignored(filepath.Join(dir, rel), true); got != want {
+			t.Errorf("ignored(%s) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+// TestIgnoreFilesFlag checks that -ignore-files reads the files named in
+// every directory, a later file and a deeper one taking precedence, and
+// leaves other ignore files alone.
+func TestIgnoreFilesFlag(t *testing.T) {
+	dir := t.TempDir()
+	writeFile(t, dir, ".gitignore", "build\nvendor\ncache\n")
+	writeFile(t, dir, ".rgignore", "!vendor\n")
+	writeFile(t, dir, ".ignore", "docs\n")
+	writeFile(t, dir, "sub/.rgignore", "!build\n")
+	mkdirs(t, dir, "build", "vendor", "cache", "docs", "sub/build", "sub/cache")
+	rt, err := newRoot(Root{Dir: dir}, Config{IgnoreFiles: []string{".gitignore", ".rgignore"}})
+	if err != nil {
+		t.Fatal(err)
+	}
+	if err := rt.loadIgnore(filepath.Join(dir, "sub")); err != nil {
+		t.Fatal(err)
+	}
+	tests := map[string]bool{
+		"build":     true,
+		"vendor":    false,
+		"cache":     true,
+		"docs":      false,
+		"sub/build": false,
+		"sub/cache": true,
+	}
+	for rel, want := range tests {
+		if got := rt.ignored(filepath.Join(dir, rel), true); got != want {
+			t.Errorf("ignored(%s) = %v, want %v", rel, got, want)
+		}
+	}
+}
+
+func TestOnlyDirs(t *testing.T) {
+	dir := t.TempDir()
+	mkdirs(t, dir, "src/app/gen", "src/app/web", "lib/util", "docs/src", "tools")
+	tests := []struct {
+		only   []string
+		ignore []string
+		want   []string
+	}{
+		{nil, nil, []string{"", "docs", "docs/src", "lib", "lib/util", "src", "src/app", "src/app/gen", "src/app/web", "tools"}},
+		{[]string{"src", "lib"}, nil, []string{"lib", "lib/util", "src", "src/app", "src/app/gen", "src/app/web"}},
+		// the directories leading to a nested one are walked, not watched.
+		{[]string{"src/app/web"}, nil, []string{"src/app/web"}},
+		// ignored directories are skipped even when allowed.
+		{[]string{"src", "lib"}, []string{"gen", "lib"}, []string{"src", "src/app", "src/app/web"}},
+		{[]string{"src/app/gen"}, []string{"app"}, []string{}},
+	}
+	for _, tt := range tests {
+		only := []string{}
+		for _, o := range tt.only {
+			only = append(only, filepath.Join(dir, o))
+		}
+		r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}, OnlyDirs: only, Ignore: tt.ignore})
+		rt := r.roots[0]
+		dirs, errs := r.discover(rt, rt.dir, true)
+		if len(errs) > 0 {
+			t.Fatal(errs)
+		}
+		got := []string{}
+		for _, d := range dirs {
+			got = append(got, rt.rel(d))
+		}
+		if !reflect.DeepEqual(got, tt.want) {
+			t.Errorf("-only-dir %q -ignore %q watches %q, want %q", tt.only, tt.ignore, got, tt.want)
+		}
+	}
+}
@@ -0,0 +1,74 @@
+package f5
+
+import (
+	"os"
+	"sync"
+	"time"
+)
+
+// settler holds back changed files until they stop changing. fsnotify has
+// no equivalent of inotify's IN_CLOSE_WRITE, and a large file write can
+// fire a Write event per chunk, so a file counts as written once its size
+// and modification time stayed the same for the settle duration.
+type settler struct {
+	mu      sync.Mutex
+	pending map[string]*settling
+}
+
+type settling struct {
+	timer *time.Timer
+	size  int64
+	mtime time.Time
+}
+
+// settle calls r.changed for path once it has not changed for
+// r.cfg.Settle.
+func (r *Run) settle(path string) {
+	s := &r.settler
+	s.mu.Lock()
+	defer s.mu.Unlock()
+	if s.pending == nil {
+		s.pending = map[string]*settling{}
+	}
+	if p, ok := s.pending[path]; ok {
+		r.debugf("settling %s: written again", path)
+		p.timer.Reset(r.cfg.Settle)
+		return
+	}
+	r.debugf("settling %s", path)
+	p := &settling{}
+	p.size, p.mtime = stat(path)
+	p.timer = time.AfterFunc(r.cfg.Settle, func() {
+		size, mtime := stat(path)
+		s.mu.Lock()
+		if size < 0 {
+			// deleted meanwhile, which the watcher reports on its own.
+			delete(s.pending, path)
+			s.mu.Unlock()
+			r.debugf("settling %s: gone", path)
+			return
+		}
+		if size != p.size || !mtime.Equal(p.mtime) {
+			r.debugf("settling %s: size %d, was %d", path, size, p.size)
+			p.size, p.mtime = size, mtime
+			p.timer.Reset(r.cfg.Settle)
+			s.mu.Unlock()
+			return
+		}
+		delete(s.pending, path)
+		s.mu.Unlock()
+		r.debugf("settled %s", path)
+		r.changed(path)
+	})
+	s.pending[path] = p
+}
+
+// stat returns the size and modification time of the file, or a size of
+// -1 if it cannot be read.
+func stat(path string) (int64, time.Time) {
+	fi, err := os.Stat(path)
+	if err != nil {
+		return -1, time.Time{}
+	}
+	return fi.Size(), fi.ModTime()
+}
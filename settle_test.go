@@ -0,0 +1,25 @@
+package f5
+
+import (
+	"os"
+	"testing"
+	"time"
+)
+
+// TestSettleDeleted checks that a file deleted while settling does not
+// restart the command, while one left alone does.
+func TestSettleDeleted(t *testing.T) {
+	dir := t.TempDir()
+	kept := writeFile(t, dir, "kept.go", "package main\n")
+	gone := writeFile(t, dir, "gone.go", "package main\n")
+	r := newTestRun(t, Config{Roots: []Root{{Dir: dir}}, Settle: 50 * time.Millisecond})
+	r.settle(kept)
+	r.settle(gone)
+	if err := os.Remove(gone); err != nil {
+		t.Fatal(err)
+	}
+	got := restarts(r)
+	if len(got) != 1 || got[0].path != kept {
+		t.Errorf("restarts %+v, want one for %s", got, kept)
+	}
+}
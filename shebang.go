@@ -0,0 +1,54 @@
+package f5
+
+import (
+	"bytes"
+	"os"
+	"path/filepath"
+	"strings"
+)
+
+// shebangPeek is how much of a file is read to find its shebang line.
+const shebangPeek = 256
+
+// interpreters are the shebang programs of the scripts watched with
+// -detect-shebang, without version suffixes.
+var interpreters = map[string]bool{
+	"sh": true, "bash": true, "zsh": true, "fish": true,
+	"python": true, "node": true, "deno": true, "bun": true,
+	"ruby": true, "perl": true, "php": true, "lua": true,
+}
+
+// hasShebang reports whether the file starts with a shebang line for one of
+// the known interpreters.
+func hasShebang(path string) bool {
+	f, err := os.Open(path)
+	if err != nil {
+		return false
+	}
+	defer f.Close()
+	buf := make([]byte, shebangPeek)
+	n, _ := f.Read(buf)
+	buf = buf[:n]
+	if !bytes.HasPrefix(buf, []byte("#!")) {
+		return false
+	}
+	if i := bytes.IndexByte(buf, '\n'); i >= 0 {
+		buf = buf[:i]
+	}
+	fields := strings.Fields(string(buf[2:]))
+	if len(fields) == 0 {
+		return false
+	}
+	prog := filepath.Base(fields[0])
+	if prog == "env" {
+		// skip the options of env, as in "#!/usr/bin/env -S python -u".
+		prog = ""
+		for _, f := range fields[1:] {
+			if !strings.HasPrefix(f, "-") {
+				prog = f
+				break
+			}
+		}
+	}
+	return interpreters[strings.TrimRight(prog, "0123456789.")]
+}
@@ -0,0 +1,55 @@
+package f5
+
+import (
+	"fmt"
+	"path/filepath"
+	"strings"
+	"testing"
+)
+
+func TestHasShebang(t *testing.T) {
+	dir := t.TempDir()
+	tests := []struct {
+		content string
+		want    bool
+	}{
+		{"#!/bin/sh\necho hi\n", true},
+		{"#!/usr/bin/env python3\n", true},
+		{"#!/usr/bin/env -S python3.11 -u\n", true},
+		{"#! /usr/local/bin/node --inspect\n", true},
+		{"#!/usr/bin/awk -f\n", false},
+		{"#!\n", false},
+		{"echo no shebang\n", false},
+		{"\x7fELF\x02\x01\x01\x00\x00#!/bin/sh", false},
+		{"", false},
+		// the shebang line is only looked for in the first bytes.
+		{"#!" + strings.Repeat(" ", shebangPeek) + "/bin/sh\n", false},
+	}
+	for i, tt := range tests {
+		path := writeFile(t, dir, fmt.Sprintf("script%d", i), tt.content)
+		if got := hasShebang(path); got != tt.want {
+			t.Errorf("hasShebang(%q) = %v, want %v", tt.content, got, tt.want)
+		}
+	}
+	if hasShebang(filepath.Join(dir, "missing")) {
+		t.Error("hasShebang(missing) = true, want false")
+	}
+}
+
+func TestDetectShebang(t *testing.T) {
+	dir := t.TempDir()
+	script := writeFile(t, dir, "bin/deploy", "#!/usr/bin/env bash\nset -e\n")
+	binary := writeFile(t, dir, "bin/tool", "\x7fELF\x02\x01\x01\x00")
+	for _, detect := range []bool{false, true} {
+		rt, err := newRoot(Root{Dir: dir}, Config{DetectShebang: detect})
+		if err != nil {
+			t.Fatal(err)
+		}
+		if got := rt.supported(script); got != detect {
+			t.Errorf("-detect-shebang=%v: supported(script) = %v, want %v", detect, got, detect)
+		}
+		if rt.supported(binary) {
+			t.Errorf("-detect-shebang=%v: supported(binary) = true, want false", detect)
+		}
+	}
+}
@@ -0,0 +1,89 @@
+package f5
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strconv"
+	"strings"
+	"syscall"
+
+	"golang.org/x/sys/unix"
+)
+
+// parseSignal returns the signal named like "USR1", "SIGUSR1" or "10".
+func parseSignal(name string) (syscall.Signal, error) {
+	if n, err := strconv.Atoi(name); err == nil && n > 0 {
+		return syscall.Signal(n), nil
+	}
+	full := strings.ToUpper(name)
+	if !strings.HasPrefix(full, "SIG") {
+		full = "SIG" + full
+	}
+	if sig := unix.SignalNum(full); sig != 0 {
+		return sig, nil
+	}
+	return 0, fmt.Errorf("unknown signal %q", name)
+}
+
+// keySignals returns the signals sent by keys, from key to signal name.
+func keySignals(bindings map[string]string) (map[string]syscall.Signal, error) {
+	sigs := map[string]syscall.Signal{}
+	for key, name := range bindings {
+		if action := keyAction(key, ""); action != "" {
+			return nil, fmt.Errorf("key %q cannot be bound, f5 uses it (%s)", key, action)
+		}
+		sig, err := parseSignal(name)
+		if err != nil {
+			return nil, fmt.Errorf("key %s: %v", key, err)
+		}
+		sigs[key] = sig
+	}
+	return sigs, nil
+}
+
+// checkSpaceKey checks the action given to -space-key.
+func checkSpaceKey(action string) error {
+	switch action {
+	case "", "restart", "pause", "none":
+		return nil
+	}
+	return fmt.Errorf("unknown -space-key action %q, expect restart, pause or none", action)
+}
+
+// signal sends sig to the running command, without restarting it.
+func (r *Run) signal(sig syscall.Signal) {
+	p, _ := r.status()
+	if p == nil || p.exited() {
+		r.printf(colorWarn, "No running process to send %s to", unix.SignalName(sig))
+		return
+	}
+	if err := syscall.Kill(r.target(p.Pid), sig); err != nil {
+		r.printf(colorError, "Process %d: cannot send %s: %v", p.Pid, unix.SignalName(sig), err)
+		return
+	}
+	r.printf(colorInfo, "Sent %s to process %d", unix.SignalName(sig), p.Pid)
+}
+
+// ListenForSignals lets other programs control f5 with signals until ctx
+// is done: SIGUSR1 restarts the command, as F5 does, and SIGUSR2 pauses or
+// resumes watching, as p does.
+func (r *Run) ListenForSignals(ctx context.Context) {
+	c := make(chan os.Signal, 1)
+	signal.Notify(c, syscall.SIGUSR1, syscall.SIGUSR2)
+	defer signal.Stop(c)
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case sig := <-c:
+			switch sig {
+			case syscall.SIGUSR1:
+				r.send(trigger{reason: "signal"})
+			case syscall.SIGUSR2:
+				r.togglePause()
+			}
+		}
+	}
+}
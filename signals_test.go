@@ -0,0 +1,27 @@
+package f5
+
+import (
+	"syscall"
+	"testing"
+)
+
+func TestKeySignals(t *testing.T) {
+	sigs, err := keySignals(map[string]string{"1": "USR1", "2": "sighup", "3": "15"})
+	if err != nil {
+		t.Fatal(err)
+	}
+	want := map[string]syscall.Signal{"1": syscall.SIGUSR1, "2": syscall.SIGHUP, "3": syscall.SIGTERM}
+	for key, sig := range want {
+		if sigs[key] != sig {
+			t.Errorf("key %s sends %v, want %v", key, sigs[key], sig)
+		}
+	}
+	for _, key := range []string{"p", "q", "o", "d", "R", " ", "F5", "ETX", "Up"} {
+		if _, err := keySignals(map[string]string{key: "USR1"}); err == nil {
+			t.Errorf("binding %q succeeded, want an error as f5 uses it", key)
+		}
+	}
+	if _, err := keySignals(map[string]string{"1": "SIGNOPE"}); err == nil {
+		t.Error("binding an unknown signal succeeded")
+	}
+}
@@ -0,0 +1,49 @@
+package f5
+
+import "time"
+
+// State is a snapshot of the runner, as returned by Run.State.
+type State struct {
+	// Status is what the command is doing: "stopped" when it is not
+	// running, before its first start or after f5 stopped it, "running",
+	// "exited" when it exited on its own without error, or "crashed" when
+	// it exited on its own with an error.
+	Status string `json:"status"`
+	// Paused is set while file changes do not restart the command.
+	Paused bool `json:"paused"`
+	// PID is the process id of the current run of the command, if any.
+	PID int `json:"pid,omitempty"`
+	// Runs is how many times the command was started, and Crashes how many
+	// of those runs exited with an error on their own.
+	Runs    int `json:"runs"`
+	Crashes int `json:"crashes"`
+	// Started is when the current run started, and Uptime how long it has
+	// been running, or ran until it exited.
+	Started time.Time     `json:"started,omitempty"`
+	Uptime  time.Duration `json:"uptime"`
+	// ExitCode is the exit code of the current run, once it exited.
+	ExitCode *int `json:"exit_code,omitempty"`
+}
+
+// State returns a snapshot of the runner. It can be called at any time,
+// from any goroutine.
+func (r *Run) State() State {
+	r.mu.Lock()
+	defer r.mu.Unlock()
+	s := State{Status: "stopped", Paused: r.paused, Runs: r.runs, Crashes: r.crashes}
+	p := r.proc
+	if p == nil {
+		return s
+	}
+	s.PID, s.Started = p.Pid, p.started
+	if !p.exited() {
+		s.Status, s.Uptime = "running", time.Since(p.started)
+		return s
+	}
+	code := exitCode(p.err)
+	s.Status, s.Uptime, s.ExitCode = "exited", p.ended.Sub(p.started), &code
+	if p.err != nil {
+		s.Status = "crashed"
+	}
+	return s
+}
@@ -0,0 +1,63 @@
+package f5
+
+import (
+	"sort"
+	"time"
+)
+
+// summaryFiles is how many of the most changed files the -summary lists.
+const summaryFiles = 5
+
+// countChange counts a change to the file for -summary.
+func (r *Run) countChange(path string) {
+	if !r.cfg.Summary {
+		return
+	}
+	r.mu.Lock()
+	r.changes[path]++
+	r.mu.Unlock()
+}
+
+// summary prints a recap of the session on shutdown, for -summary: the
+// restarts and crashes, how long f5 ran and the files changed most often.
+func (r *Run) summary() {
+	r.mu.Lock()
+	restarts, crashes, began := r.runs-1, r.crashes, r.began
+	files := make([]string, 0, len(r.changes))
+	for f := range r.changes {
+		files = append(files, f)
+	}
+	sort.Slice(files, func(i, j int) bool {
+		if r.changes[files[i]] != r.changes[files[j]] {
+			return r.changes[files[i]] > r.changes[files[j]]
+		}
+		return files[i] < files[j]
+	})
+	counts := []int{}
+	for _, f := range files {
+		counts = append(counts, r.changes[f])
+	}
+	r.mu.Unlock()
+	if restarts < 0 {
+		restarts = 0
+	}
+	took := time.Duration(0)
+	if !began.IsZero() {
+		took = time.Since(began).Round(time.Second)
+	}
+	c := colorSuccess
+	if crashes > 0 {
+		c = colorWarn
+	}
+	r.usagef(c, "Session summary: %d restarts, %d crashes in %s", restarts, crashes, took)
+	if len(files) == 0 {
+		return
+	}
+	r.usagef(colorInfo, "Most changed files:")
+	for i, f := range files {
+		if i == summaryFiles {
+			break
+		}
+		r.usagef(colorInfo, "%5d  %s", counts[i], r.paintPath(colorInfo, f))
+	}
+}
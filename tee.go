@@ -0,0 +1,70 @@
+package f5
+
+import (
+	"os"
+	"sync"
+)
+
+// tee is the file the output of the command is copied to, for -tee.
+type tee struct {
+	path   string
+	append bool
+
+	mu sync.Mutex
+	f  *os.File
+}
+
+// openTee opens the file, truncating it unless output is appended to it.
+func openTee(path string, append bool) (*tee, error) {
+	t := &tee{path: path, append: append}
+	f, err := t.open(!append)
+	if err != nil {
+		return nil, err
+	}
+	t.f = f
+	return t, nil
+}
+
+func (t *tee) open(truncate bool) (*os.File, error) {
+	flags := os.O_WRONLY | os.O_CREATE | os.O_APPEND
+	if truncate {
+		flags |= os.O_TRUNC
+	}
+	return os.OpenFile(t.path, flags, 0o644)
+}
+
+func (t *tee) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f == nil {
+		return len(p), nil
+	}
+	// a file that cannot be written must not stop the output.
+	t.f.Write(p)
+	return len(p), nil
+}
+
+// rotate starts the file anew for a new run, unless output is appended to
+// it. The file is opened again, in case it was moved or removed.
+func (t *tee) rotate() error {
+	if t.append {
+		return nil
+	}
+	f, err := t.open(true)
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f != nil {
+		t.f.Close()
+	}
+	t.f = f
+	return err
+}
+
+func (t *tee) close() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.f != nil {
+		t.f.Close()
+		t.f = nil
+	}
+}
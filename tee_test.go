@@ -0,0 +1,65 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"io"
+	"os"
+	"path/filepath"
+	"testing"
+)
+
+func TestTee(t *testing.T) {
+	path := filepath.Join(t.TempDir(), "logs", "app.log")
+	os.MkdirAll(filepath.Dir(path), 0o755)
+	os.WriteFile(path, []byte("old\n"), 0o644)
+	x, err := openTee(path, false)
+	if err != nil {
+		t.Fatal(err)
+	}
+	var term bytes.Buffer
+	w := io.MultiWriter(&term, x)
+	fmt.Fprint(w, "run 1\n")
+	if err := x.rotate(); err != nil {
+		t.Fatal(err)
+	}
+	fmt.Fprint(w, "run 2\n")
+	x.close()
+	// written after close, as by a process still exiting.
+	fmt.Fprint(w, "late\n")
+	if want := "run 1\nrun 2\nlate\n"; term.String() != want {
+		t.Errorf("terminal got %q, want %q", term.String(), want)
+	}
+	if b, _ := os.ReadFile(path); string(b) != "run 2\n" {
+		t.Errorf("file got %q, want the last run", b)
+	}
+}
+
+// TestTeeRuns checks the file against the output of several runs of the
+// command, started anew for each run or appended to with -tee-append.
+func TestTeeRuns(t *testing.T) {
+	for _, appended := range []bool{false, true} {
+		path := filepath.Join(t.TempDir(), "app.log")
+		os.WriteFile(path, []byte("before\n"), 0o644)
+		r, err := NewWithConfig(Config{Roots: []Root{{Dir: t.TempDir()}}, Tee: path, TeeAppend: appended}, "sh", "-c", "echo run $$")
+		if err != nil {
+			t.Fatal(err)
+		}
+		pids := []int{}
+		for i := 0; i < 2; i++ {
+			r.Restart(context.Background())
+			p, _ := r.status()
+			<-p.done
+			pids = append(pids, p.Pid)
+		}
+		r.Close()
+		want := fmt.Sprintf("run %d\n", pids[1])
+		if appended {
+			want = fmt.Sprintf("before\nrun %d\nrun %d\n", pids[0], pids[1])
+		}
+		if b, _ := os.ReadFile(path); string(b) != want {
+			t.Errorf("-tee-append=%v: file got %q, want %q", appended, b, want)
+		}
+	}
+}
@@ -0,0 +1,168 @@
+package f5
+
+import (
+	"fmt"
+	"os"
+	"path/filepath"
+	"sort"
+)
+
+// color is the meaning of a message, rendered by the theme of the run.
+type color int
+
+const (
+	colorInfo color = iota
+	colorSuccess
+	colorWarn
+	colorError
+	colorAccent
+	colorDebug
+	numColors
+)
+
+const ansiReset = "\033[0m"
+
+// theme maps each color to its ANSI escape sequence. The mono theme has
+// none, and prints no escape sequences at all.
+type theme [numColors]string
+
+var themes = map[string]theme{
+	"default": {
+		colorInfo:    "\033[37m",
+		colorSuccess: "\033[32m",
+		colorWarn:    "\033[33m",
+		colorError:   "\033[31m",
+		colorAccent:  "\033[36m",
+		colorDebug:   "\033[34m",
+	},
+	"dark": {
+		colorInfo:    "\033[97m",
+		colorSuccess: "\033[92m",
+		colorWarn:    "\033[93m",
+		colorError:   "\033[91m",
+		colorAccent:  "\033[96m",
+		colorDebug:   "\033[94m",
+	},
+	"light": {
+		colorInfo:    "\033[30m",
+		colorSuccess: "\033[32m",
+		colorWarn:    "\033[35m",
+		colorError:   "\033[31m",
+		colorAccent:  "\033[34m",
+		colorDebug:   "\033[90m",
+	},
+	"high-contrast": {
+		colorInfo:    "\033[1;97m",
+		colorSuccess: "\033[1;92m",
+		colorWarn:    "\033[1;93m",
+		colorError:   "\033[1;91m",
+		colorAccent:  "\033[1;96m",
+		colorDebug:   "\033[1;94m",
+	},
+	"mono": {},
+}
+
+// Themes returns the names of the color themes.
+func Themes() []string {
+	names := []string{}
+	for n := range themes {
+		names = append(names, n)
+	}
+	sort.Strings(names)
+	return names
+}
+
+// lookupTheme returns the named theme, as resolved by themeName.
+func lookupTheme(name string) (theme, error) {
+	t, ok := themes[name]
+	if !ok {
+		return t, fmt.Errorf("unknown theme %q, expect one of %q", name, Themes())
+	}
+	return t, nil
+}
+
+// themeName returns the name of the theme used for name with the color
+// mode: "mono" with "never", or with "auto" when NO_COLOR is set or the
+// error output is not a terminal. Otherwise name, which defaults to
+// "default", is used, so "always" overrides NO_COLOR.
+func themeName(name, mode string) string {
+	switch {
+	case mode == "never":
+		return "mono"
+	case mode != "always" && (os.Getenv("NO_COLOR") != "" || !isatty(os.Stderr)):
+		return "mono"
+	case name == "":
+		return "default"
+	}
+	return name
+}
+
+// checkColor checks the color mode given to -color.
+func checkColor(mode string) error {
+	switch mode {
+	case "", "auto", "always", "never":
+		return nil
+	}
+	return fmt.Errorf("unknown -color mode %q, expect auto, always or never", mode)
+}
+
+// reset returns the sequence ending a colored message.
+func (t *theme) reset() string {
+	if t[colorInfo] == "" {
+		return ""
+	}
+	return ansiReset
+}
+
+// ansiColors are the color names accepted for file extensions.
+var ansiColors = map[string]string{
+	"black":   "\033[30m",
+	"red":     "\033[31m",
+	"green":   "\033[32m",
+	"yellow":  "\033[33m",
+	"blue":    "\033[34m",
+	"magenta": "\033[35m",
+	"cyan":    "\033[36m",
+	"white":   "\033[37m",
+	"gray":    "\033[90m",
+}
+
+// defaultExtColors colors the names of changed files by language.
+var defaultExtColors = map[string]string{
+	".go":   "cyan",
+	".py":   "yellow",
+	".js":   "yellow",
+	".ts":   "blue",
+	".java": "red",
+	".rb":   "red",
+	".php":  "magenta",
+	".cs":   "magenta",
+	".c":    "blue",
+	".cpp":  "blue",
+}
+
+// extColors returns the escape sequences for file extensions, from the
+// defaults overridden by overrides.
+func extColors(overrides map[string]string) (map[string]string, error) {
+	colors := map[string]string{}
+	for _, m := range []map[string]string{defaultExtColors, overrides} {
+		for ext, name := range m {
+			c, ok := ansiColors[name]
+			if !ok {
+				return nil, fmt.Errorf("unknown color %q for %s", name, ext)
+			}
+			colors[normalizeExt(ext)] = c
+		}
+	}
+	return colors, nil
+}
+
+// paintPath colors the name of the file by its extension, to be printed
+// within a message of color c.
+func (r *Run) paintPath(c color, path string) string {
+	code, ok := r.extColors[filepath.Ext(path)]
+	if !ok || r.theme.reset() == "" {
+		return path
+	}
+	return code + path + r.theme[c]
+}
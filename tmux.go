@@ -0,0 +1,84 @@
+package f5
+
+import (
+	"context"
+	"fmt"
+	"os"
+	"os/exec"
+	"strings"
+	"syscall"
+	"time"
+
+	"golang.org/x/sys/unix"
+)
+
+// paneHold is the command of the tmux pane the command runs in with
+// -tmux. It only holds the pane open, without reading its input or being
+// stopped by Ctrl-C there, as the command reads and writes the terminal of
+// the pane.
+const paneHold = "trap '' INT QUIT TSTP; while :; do sleep 3600; done"
+
+// paneResize is how often the size of the tmux pane is checked, for the
+// command to be told when it changes.
+const paneResize = 500 * time.Millisecond
+
+// pane is the tmux pane the command runs in with -tmux.
+type pane struct {
+	id  string
+	tty *os.File
+}
+
+// openPane splits the tmux window f5 runs in for the command, or returns
+// nil when f5 does not run in tmux.
+func (r *Run) openPane() (*pane, error) {
+	if os.Getenv("TMUX") == "" {
+		r.printf(colorWarn, "Not running in tmux, running the command here instead of in a pane")
+		return nil, nil
+	}
+	out, err := exec.Command("tmux", "split-window", "-d", "-P", "-F", "#{pane_id} #{pane_tty}", paneHold).Output()
+	if err != nil {
+		return nil, fmt.Errorf("cannot split the tmux window: %v", err)
+	}
+	id, path, ok := strings.Cut(strings.TrimSpace(string(out)), " ")
+	if !ok {
+		return nil, fmt.Errorf("unexpected tmux output %q", out)
+	}
+	tty, err := os.OpenFile(path, os.O_RDWR, 0)
+	if err != nil {
+		exec.Command("tmux", "kill-pane", "-t", id).Run()
+		return nil, err
+	}
+	r.printf(colorInfo, "Running the command in tmux pane %s", id)
+	return &pane{id: id, tty: tty}, nil
+}
+
+// close removes the pane.
+func (pn *pane) close() {
+	pn.tty.Close()
+	exec.Command("tmux", "kill-pane", "-t", pn.id).Run()
+}
+
+// resizePane tells the running command when the size of its pane changes
+// until ctx is done, as only the process holding the pane is signaled.
+func (r *Run) resizePane(ctx context.Context) {
+	t := time.NewTicker(paneResize)
+	defer t.Stop()
+	var last *unix.Winsize
+	for {
+		select {
+		case <-ctx.Done():
+			return
+		case <-t.C:
+		}
+		ws, err := unix.IoctlGetWinsize(int(r.pane.tty.Fd()), unix.TIOCGWINSZ)
+		if err != nil {
+			continue
+		}
+		if last != nil && *ws != *last {
+			if p, _ := r.status(); p != nil && !p.exited() {
+				syscall.Kill(r.target(p.Pid), syscall.SIGWINCH)
+			}
+		}
+		last = ws
+	}
+}
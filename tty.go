@@ -0,0 +1,102 @@
+package f5
+
+import (
+	"os"
+	"os/exec"
+	"os/signal"
+	"sync"
+	"syscall"
+	"time"
+
+	"github.com/tj/go-terminput"
+	"golang.org/x/sys/unix"
+)
+
+// keyPoll is how long a read of a key waits with -stdin, so that the
+// terminal can be handed to the command soon after it starts.
+const keyPoll = 100 * time.Millisecond
+
+// handoff hands the terminal over to the command while it runs with
+// -stdin, and back to f5 for its keys once it exits.
+type handoff struct {
+	// reading is held while f5 reads a key, and child is set while the
+	// command has the terminal, guarded by reading.
+	reading sync.Mutex
+	child   bool
+}
+
+// prepareStdin sets the command up to read from f5's standard input. When
+// it is the terminal f5 reads keys on, the command gets it as its
+// controlling terminal in the foreground and in the mode f5 found it in,
+// and f5 stops reading keys until the command exits.
+func (r *Run) prepareStdin(cmd *exec.Cmd) {
+	cmd.Stdin = os.Stdin
+	if r.term == nil {
+		return
+	}
+	// wait for a key being read to time out, so that f5 does not take
+	// the first key meant for the command.
+	r.handoff.reading.Lock()
+	r.handoff.child = true
+	r.handoff.reading.Unlock()
+	r.term.Restore()
+	cmd.SysProcAttr.Foreground = true
+	cmd.SysProcAttr.Ctty = 0
+}
+
+// reclaimTTY takes the terminal back for f5's keys after the run p exited,
+// unless another run has it already.
+func (r *Run) reclaimTTY(p *proc) {
+	if r.term == nil {
+		return
+	}
+	fd := int(os.Stdin.Fd())
+	if pgrp, err := unix.IoctlGetInt(fd, unix.TIOCGPGRP); err != nil || pgrp != p.Pid {
+		return
+	}
+	foreground()
+	r.resumeKeys()
+}
+
+// resumeKeys goes back to reading keys.
+func (r *Run) resumeKeys() {
+	r.keyMode()
+	r.handoff.reading.Lock()
+	r.handoff.child = false
+	r.handoff.reading.Unlock()
+}
+
+// foreground makes f5 the foreground process group of its terminal again.
+func foreground() {
+	// f5 is in the background until then, where changing the terminal
+	// would stop it.
+	signal.Ignore(syscall.SIGTTOU)
+	defer signal.Reset(syscall.SIGTTOU)
+	unix.IoctlSetPointerInt(int(os.Stdin.Fd()), unix.TIOCSPGRP, syscall.Getpgrp())
+}
+
+// keyMode puts the terminal in the mode f5 reads keys in.
+func (r *Run) keyMode() {
+	r.term.SetCbreak()
+	if r.cfg.Stdin {
+		r.term.SetReadTimeout(keyPoll)
+	}
+}
+
+// readKey reads a key, or returns nil when there is none, such as while
+// the command has the terminal.
+func (r *Run) readKey() *terminput.KeyboardInput {
+	if !r.cfg.Stdin {
+		e, _ := terminput.Read(r.term)
+		return e
+	}
+	r.handoff.reading.Lock()
+	if r.handoff.child {
+		r.handoff.reading.Unlock()
+		time.Sleep(keyPoll)
+		return nil
+	}
+	e, _ := terminput.Read(r.term)
+	r.handoff.reading.Unlock()
+	return e
+}
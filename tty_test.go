@@ -0,0 +1,118 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"os"
+	"os/exec"
+	"strings"
+	"sync"
+	"testing"
+	"time"
+
+	"github.com/creack/pty"
+	"golang.org/x/sys/unix"
+)
+
+// replEnv makes the test binary run f5 with -stdin on its terminal, for
+// TestStdinREPL to drive through a pty.
+const replEnv = "F5_TEST_REPL"
+
+// TestStdinREPL checks that with -stdin an interactive command gets the
+// terminal in the mode f5 found it in, reading lines with echo, and that
+// f5 takes the terminal back for its keys once the command exits.
+func TestStdinREPL(t *testing.T) {
+	if os.Getenv(replEnv) != "" {
+		stdinREPL()
+		return
+	}
+	cmd := exec.Command(os.Args[0], "-test.run=^TestStdinREPL$")
+	cmd.Env = append(os.Environ(), replEnv+"=1")
+	tty, err := pty.Start(cmd)
+	if err != nil {
+		t.Skip(err)
+	}
+	defer tty.Close()
+	var (
+		mu  sync.Mutex
+		out bytes.Buffer
+	)
+	go func() {
+		buf := make([]byte, 1024)
+		for {
+			n, err := tty.Read(buf)
+			mu.Lock()
+			out.Write(buf[:n])
+			mu.Unlock()
+			if err != nil {
+				return
+			}
+		}
+	}()
+	await := func(what string, done func() bool) {
+		t.Helper()
+		for deadline := time.Now().Add(10 * time.Second); !done(); time.Sleep(10 * time.Millisecond) {
+			if time.Now().After(deadline) {
+				cmd.Process.Kill()
+				mu.Lock()
+				defer mu.Unlock()
+				t.Fatalf("no %s, terminal showed %q", what, out.String())
+			}
+		}
+	}
+	shown := func(s string) func() bool {
+		return func() bool {
+			mu.Lock()
+			defer mu.Unlock()
+			return strings.Contains(out.String(), s)
+		}
+	}
+
+	await("prompt", shown("prompt> "))
+	tty.WriteString("hello\n")
+	await("answer", shown("got hello"))
+	mu.Lock()
+	// the terminal echoed the line too, as it does in cooked mode.
+	if n := strings.Count(out.String(), "hello\r\n"); n != 2 {
+		t.Errorf("line shown %d times, want 2 with the echo: %q", n, out.String())
+	}
+	mu.Unlock()
+	await("cbreak mode once the command exited", func() bool {
+		a, err := unix.IoctlGetTermios(int(tty.Fd()), unix.TCGETS)
+		return err == nil && a.Lflag&unix.ICANON == 0
+	})
+	tty.WriteString("q")
+	done := make(chan error, 1)
+	go func() { done <- cmd.Wait() }()
+	select {
+	case err := <-done:
+		if err != nil {
+			t.Errorf("f5 exited with %v", err)
+		}
+	case <-time.After(10 * time.Second):
+		cmd.Process.Kill()
+		t.Error("f5 did not quit on q after the command exited")
+	}
+}
+
+// stdinREPL runs a REPL-like command with -stdin until q is pressed.
+func stdinREPL() {
+	dir, err := os.MkdirTemp("", "f5repl")
+	if err != nil {
+		os.Exit(2)
+	}
+	defer os.RemoveAll(dir)
+	r, err := NewWithConfig(Config{Roots: []Root{{Dir: dir}}, Stdin: true, Color: "never"},
+		"sh", "-c", `printf 'prompt> '; read line; echo "got $line"`)
+	if err != nil {
+		os.Exit(2)
+	}
+	ctx, cancel := context.WithCancel(context.Background())
+	defer cancel()
+	if err := r.Start(ctx); err != nil {
+		os.Exit(2)
+	}
+	go r.ListenForKeys(ctx)
+	r.Wait()
+	os.Exit(0)
+}
@@ -0,0 +1,244 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"fmt"
+	"os"
+	"os/signal"
+	"strings"
+	"sync"
+	"syscall"
+	"time"
+	"unicode/utf8"
+
+	"golang.org/x/sys/unix"
+)
+
+const (
+	// tuiHistory is the number of output lines kept for scrolling.
+	tuiHistory = 5000
+	// tuiRefresh is how often the screen is redrawn.
+	tuiRefresh = 100 * time.Millisecond
+)
+
+// tui renders a full screen panel with a status header, a scrollable pane
+// of recent output and a footer with the key bindings.
+//
+//	f5 | pid 1234 | up 1m2s | runs 3 | watching
+//	...output of the command and f5...
+//	F5/space restart | p pause | d debug | R rewatch | up/down scroll | q quit
+//
+// It is an io.Writer, so it can be used as the output of both the command
+// and f5's own logger.
+type tui struct {
+	r   *Run
+	out *os.File
+
+	mu      sync.Mutex
+	lines   []string
+	partial []byte
+	scroll  int
+	width   int
+	height  int
+	stopped bool
+}
+
+func newTUI(r *Run) *tui {
+	return &tui{r: r, out: os.Stdout, width: 80, height: 24}
+}
+
+// Write appends output to the pane, one line at a time.
+func (t *tui) Write(p []byte) (int, error) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.partial = append(t.partial, p...)
+	for {
+		i := bytes.IndexByte(t.partial, '\n')
+		if i < 0 {
+			break
+		}
+		line := strings.TrimSuffix(string(t.partial[:i]), "\r")
+		t.partial = t.partial[i+1:]
+		t.lines = append(t.lines, line)
+		// keep the position of the view while scrolled back.
+		if t.scroll > 0 {
+			t.scroll++
+		}
+	}
+	if len(t.lines) > tuiHistory {
+		t.lines = append([]string(nil), t.lines[len(t.lines)-tuiHistory:]...)
+	}
+	return len(p), nil
+}
+
+// start switches to the alternate screen and redraws it until ctx is done.
+func (t *tui) start(ctx context.Context) {
+	t.resize()
+	fmt.Fprint(t.out, "\033[?1049h\033[?25l")
+	winch := make(chan os.Signal, 1)
+	signal.Notify(winch, syscall.SIGWINCH)
+	go func() {
+		defer signal.Stop(winch)
+		ticker := time.NewTicker(tuiRefresh)
+		defer ticker.Stop()
+		for {
+			select {
+			case <-ctx.Done():
+				return
+			case <-winch:
+				t.resize()
+			case <-ticker.C:
+			}
+			t.draw()
+		}
+	}()
+}
+
+// stop restores the normal screen, and replays the end of the output so it
+// is not lost with the alternate screen.
+func (t *tui) stop() {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.stopped = true
+	fmt.Fprint(t.out, "\033[?25h\033[?1049l")
+	from := len(t.lines) - t.height
+	if from < 0 {
+		from = 0
+	}
+	for _, l := range t.lines[from:] {
+		fmt.Fprintln(t.out, l+ansiReset)
+	}
+}
+
+func (t *tui) resize() {
+	ws, err := unix.IoctlGetWinsize(int(t.out.Fd()), unix.TIOCGWINSZ)
+	if err != nil || ws.Col == 0 || ws.Row < 3 {
+		return
+	}
+	t.mu.Lock()
+	t.width, t.height = int(ws.Col), int(ws.Row)
+	t.mu.Unlock()
+}
+
+// scrollBy moves the view n lines back in history; negative n moves
+// forward.
+func (t *tui) scrollBy(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scrollLines(n)
+}
+
+// scrollPages moves the view n pages back in history, keeping a line of
+// the page left in view; negative n moves forward.
+func (t *tui) scrollPages(n int) {
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	t.scrollLines(n * (t.paneHeight() - 1))
+}
+
+// scrollLines moves the view n lines back. t.mu must be held.
+func (t *tui) scrollLines(n int) {
+	t.scroll += n
+	if max := len(t.lines) - t.paneHeight(); t.scroll > max {
+		t.scroll = max
+	}
+	if t.scroll < 0 {
+		t.scroll = 0
+	}
+}
+
+// paneHeight returns the number of lines of output shown. t.mu must be
+// held.
+func (t *tui) paneHeight() int {
+	return t.height - 2
+}
+
+func (t *tui) draw() {
+	// read the state of the run before locking the pane, so the two locks
+	// are never held together.
+	header := t.header()
+	t.mu.Lock()
+	defer t.mu.Unlock()
+	if t.stopped {
+		return
+	}
+	var b strings.Builder
+	b.WriteString("\033[H")
+	b.WriteString("\033[7m" + pad(header, t.width) + "\033[0m\r\n")
+	end := len(t.lines) - t.scroll
+	start := end - t.paneHeight()
+	for i := start; i < end; i++ {
+		b.WriteString("\033[2K")
+		if i >= 0 {
+			b.WriteString(truncate(t.lines[i], t.width) + ansiReset)
+		}
+		b.WriteString("\r\n")
+	}
+	footer := " F5/space restart | p pause | d debug | R rewatch | up/down scroll | q quit"
+	if t.scroll > 0 {
+		footer += fmt.Sprintf(" | %d lines below", t.scroll)
+	}
+	b.WriteString("\033[7m" + pad(footer, t.width) + "\033[0m")
+	fmt.Fprint(t.out, b.String())
+}
+
+func (t *tui) header() string {
+	s := t.r.State()
+	state := "watching"
+	if s.Paused {
+		state = "paused"
+	}
+	switch s.Status {
+	case "stopped":
+		return fmt.Sprintf(" f5 | no process | runs %d | %s", s.Runs, state)
+	case "exited", "crashed":
+		return fmt.Sprintf(" f5 | pid %d %s (%d) | runs %d | %s", s.PID, s.Status, *s.ExitCode, s.Runs, state)
+	}
+	return fmt.Sprintf(" f5 | pid %d | up %s | runs %d | %s", s.PID, s.Uptime.Round(time.Second), s.Runs, state)
+}
+
+// pad returns s cut or padded with spaces to exactly width columns.
+func pad(s string, width int) string {
+	s = truncate(s, width)
+	if n := width - utf8.RuneCountInString(s); n > 0 {
+		s += strings.Repeat(" ", n)
+	}
+	return s
+}
+
+// truncate cuts s to width visible columns, keeping ANSI escape sequences
+// intact.
+func truncate(s string, width int) string {
+	var b strings.Builder
+	n := 0
+	for i := 0; i < len(s); {
+		if s[i] == '\033' {
+			// copy the escape sequence up to its final byte.
+			j := i + 1
+			if j < len(s) && s[j] == '[' {
+				j++
+				for j < len(s) && (s[j] < 0x40 || s[j] > 0x7e) {
+					j++
+				}
+			}
+			if j < len(s) {
+				j++
+			}
+			b.WriteString(s[i:j])
+			i = j
+			continue
+		}
+		c, size := utf8.DecodeRuneInString(s[i:])
+		if n == width {
+			break
+		}
+		if c == '\t' {
+			c = ' '
+		}
+		b.WriteRune(c)
+		n++
+		i += size
+	}
+	return b.String()
+}
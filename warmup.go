@@ -0,0 +1,72 @@
+package f5
+
+import (
+	"context"
+	"io"
+	"net/http"
+	"strings"
+	"time"
+)
+
+const (
+	// warmupTimeout bounds the -warmup-url request, which is slow by
+	// nature on a cold server.
+	warmupTimeout = 30 * time.Second
+	// warmupRetry is how often the -warmup-url request is tried until the
+	// server answers, without -ready-regex to tell when it listens.
+	warmupRetry = 250 * time.Millisecond
+)
+
+// newWarmup returns the -warmup-url request.
+func newWarmup(ctx context.Context, cfg Config) (*http.Request, error) {
+	return http.NewRequestWithContext(ctx, cfg.WarmupMethod, cfg.WarmupURL, strings.NewReader(cfg.WarmupBody))
+}
+
+// warmUp sends the -warmup-url request once the run p is ready, to prime
+// the server before it is used. With retry, it tries again until the
+// server answers or p exits, as it may not listen yet.
+func (r *Run) warmUp(ctx context.Context, p *proc, retry bool) {
+	ctx, cancel := context.WithCancel(ctx)
+	defer cancel()
+	go func() {
+		select {
+		case <-ctx.Done():
+		case <-p.done:
+			cancel()
+		}
+	}()
+	client := &http.Client{Timeout: warmupTimeout}
+	for {
+		req, err := newWarmup(ctx, r.cfg)
+		if err != nil {
+			r.printf(colorError, "Warmup request failed: %v", err)
+			return
+		}
+		began := time.Now()
+		resp, err := client.Do(req)
+		if ctx.Err() != nil {
+			return
+		}
+		if err == nil {
+			io.Copy(io.Discard, resp.Body)
+			resp.Body.Close()
+			took := time.Since(began).Round(time.Millisecond)
+			c := colorSuccess
+			if resp.StatusCode >= 400 {
+				c = colorWarn
+			}
+			r.printf(c, "Warmup request %s %s: %s in %s", req.Method, r.cfg.WarmupURL, resp.Status, took)
+			return
+		}
+		if !retry {
+			r.printf(colorError, "Warmup request %s %s failed: %v", req.Method, r.cfg.WarmupURL, err)
+			return
+		}
+		r.debugf("warmup request: %v, retrying in %s", err, warmupRetry)
+		select {
+		case <-ctx.Done():
+			return
+		case <-time.After(warmupRetry):
+		}
+	}
+}
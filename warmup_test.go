@@ -0,0 +1,67 @@
+package f5
+
+import (
+	"bytes"
+	"context"
+	"io"
+	"log"
+	"net"
+	"net/http"
+	"net/http/httptest"
+	"os"
+	"strings"
+	"testing"
+	"time"
+)
+
+func TestWarmUp(t *testing.T) {
+	got := make(chan string, 1)
+	srv := httptest.NewServer(http.HandlerFunc(func(w http.ResponseWriter, req *http.Request) {
+		body, _ := io.ReadAll(req.Body)
+		got <- req.Method + " " + req.URL.Path + " " + string(body)
+	}))
+	defer srv.Close()
+	r := newTestRun(t, Config{WarmupURL: srv.URL + "/prime", WarmupMethod: "POST", WarmupBody: "{}", Color: "never"})
+	var out bytes.Buffer
+	r.logger = log.New(&out, "", 0)
+	p := &proc{Process: &os.Process{Pid: 42}, done: make(chan struct{})}
+	r.warmUp(context.Background(), p, false)
+	select {
+	case req := <-got:
+		if req != "POST /prime {}" {
+			t.Errorf("server got %q, want the warmup request", req)
+		}
+	default:
+		t.Fatal("server got no warmup request")
+	}
+	if !strings.Contains(out.String(), "Warmup request POST "+srv.URL+"/prime: 200 OK in ") {
+		t.Errorf("reported %q, want the warmup request done", out.String())
+	}
+}
+
+// TestWarmUpRetry checks that without -ready-regex, the request is tried
+// until the server listens.
+func TestWarmUpRetry(t *testing.T) {
+	l, err := net.Listen("tcp", "localhost:0")
+	if err != nil {
+		t.Fatal(err)
+	}
+	addr := l.Addr().String()
+	l.Close()
+	r := newTestRun(t, Config{WarmupURL: "http://" + addr + "/"})
+	got := make(chan struct{}, 1)
+	srv := &http.Server{Handler: http.HandlerFunc(func(http.ResponseWriter, *http.Request) { got <- struct{}{} })}
+	defer srv.Close()
+	time.AfterFunc(2*warmupRetry, func() {
+		if l, err := net.Listen("tcp", addr); err == nil {
+			srv.Serve(l)
+		}
+	})
+	p := &proc{Process: &os.Process{Pid: 42}, done: make(chan struct{})}
+	r.warmUp(context.Background(), p, true)
+	select {
+	case <-got:
+	default:
+		t.Fatal("server got no warmup request")
+	}
+}